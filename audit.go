@@ -0,0 +1,358 @@
+package gorme
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// 审计日志,建表可用db.AutoMigrate(&gorme.AuditLog{})
+type AuditLog struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	Table     string    `gorm:"column:table_name;size:64;index:idx_audit_record" json:"table_name"`
+	RecordId  string    `gorm:"size:64;index:idx_audit_record" json:"record_id"`
+	Operation string    `gorm:"size:16" json:"operation"` //create,update,delete,restore
+	Before    string    `gorm:"type:text" json:"before"`  //修改前的值,JSON,更新时只含修改的列
+	After     string    `gorm:"type:text" json:"after"`   //修改后的值,JSON,更新时只含修改的列
+	Actor     string    `gorm:"size:64" json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (AuditLog) TableName() string {
+	return "gorme_audit_log"
+}
+
+func (model AuditLog) GetID() any {
+	return model.ID
+}
+
+type actorKey struct{}
+
+// 把操作人放入context,审计日志和FieldFiller从中读取
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// 开启后,Create/Save/Updates/Delete及CreateMany/UpdateMany/BatchUpdate/RotateKeys等写操作在同一事务中写入审计日志
+// Upsert/InsertIgnore/Replace按主键和唯一索引在写入前后读取冲突的行,Replace删除后重新插入的行记为delete和create
+// InsertInto写入的是其它表,不记录审计日志
+// 操作人通过WithContext(gorme.ContextWithActor(ctx, actor))传入
+func (r *Repository[T]) SetAudit(enabled bool) *Repository[T] {
+	r.audit = enabled
+	return r
+}
+
+// 查询主键为id的记录的审计日志,按时间先后排序
+func (r *Repository[T]) History(id any) ([]AuditLog, error) {
+	var t T
+	var logs []AuditLog
+	err := r.DB.Session(&gorm.Session{NewDB: true}).
+		Where("table_name = ? AND record_id = ?", t.TableName(), fmt.Sprint(id)).
+		Order("id").Find(&logs).Error
+	r.Reset()
+	return logs, r.translateError(err)
+}
+
+// 开启审计时,在事务中执行写操作并写入审计日志
+// value为写入的数据,有主键时按主键记录,否则按当前条件查出修改前的数据
+func (r *Repository[T]) audited(operation string, value any, exec func(db *gorm.DB) *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	if !r.audit {
+		return exec
+	}
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		return exec
+	}
+
+	return r.auditTx(exec, func(db *gorm.DB) (map[string]map[string]any, error) {
+		return r.auditBefore(db, s, operation, value)
+	}, func(db *gorm.DB, before map[string]map[string]any) (map[string]map[string]any, error) {
+		return r.auditAfter(db, s, value, before)
+	})
+}
+
+// 开启审计时,插入冲突的写操作在事务中写入审计日志
+// 写入前后按冲突列读取行,columns为空时按主键和唯一索引
+func (r *Repository[T]) auditedConflict(value any, columns []string, exec func(db *gorm.DB) *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	if !r.audit {
+		return exec
+	}
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		return exec
+	}
+
+	read := func(db *gorm.DB) (map[string]map[string]any, error) {
+		return r.auditConflicts(db, s, value, columns)
+	}
+	return r.auditTx(exec, read, func(db *gorm.DB, before map[string]map[string]any) (map[string]map[string]any, error) {
+		//按冲突列重新读取,指定了冲突列时再按插入后回填的主键读取
+		after, err := read(db)
+		if err != nil || len(columns) == 0 {
+			return after, err
+		}
+		inserted, err := r.auditRows(db, s, r.primaryKeys(s, value))
+		for id, row := range inserted {
+			after[id] = row
+		}
+		return after, err
+	})
+}
+
+// 在事务中读取写入前的数据、执行写操作、读取写入后的数据并写入审计日志
+func (r *Repository[T]) auditTx(exec func(db *gorm.DB) *gorm.DB,
+	readBefore func(db *gorm.DB) (map[string]map[string]any, error),
+	readAfter func(db *gorm.DB, before map[string]map[string]any) (map[string]map[string]any, error)) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		var tx *gorm.DB
+		err := db.Transaction(func(db *gorm.DB) error {
+			before, err := readBefore(db)
+			if err != nil {
+				return err
+			}
+			tx = exec(db)
+			if tx.Error != nil {
+				return tx.Error
+			}
+			after, err := readAfter(db, before)
+			if err != nil {
+				return err
+			}
+			logs := r.auditLogs(db.Statement.Context, before, after)
+			if len(logs) == 0 {
+				return nil
+			}
+			return db.Session(&gorm.Session{NewDB: true}).Create(&logs).Error
+		})
+		if tx == nil {
+			tx = db.Session(&gorm.Session{})
+		}
+		if err != nil && tx.Error == nil {
+			tx.AddError(err)
+		}
+		return tx
+	}
+}
+
+// 修改前的数据,按主键记录值
+// 写入的数据为模型时按其主键查询,否则按当前条件查询
+func (r *Repository[T]) auditBefore(db *gorm.DB, s *schema.Schema, operation string, value any) (map[string]map[string]any, error) {
+	if operation == "create" {
+		return nil, nil
+	}
+	if rows := r.rowsOf(value); len(rows) > 0 {
+		return r.auditRows(db, s, r.primaryKeys(s, value))
+	}
+
+	var rows []*T
+	query := db.Session(&gorm.Session{}).Select(r.DB.Statement.Table + ".*")
+	query.Statement.Omits = nil
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return snapshotRows(s, rows), nil
+}
+
+// 修改后的数据,按修改前的主键和写入数据的主键重新查询
+func (r *Repository[T]) auditAfter(db *gorm.DB, s *schema.Schema, value any, before map[string]map[string]any) (map[string]map[string]any, error) {
+	keys := r.primaryKeys(s, value)
+	for _, row := range before {
+		keys = append(keys, row[s.PrioritizedPrimaryField.DBName])
+	}
+	return r.auditRows(db, s, keys)
+}
+
+// 按冲突列查询写入的数据对应的行,包括已软删除的
+func (r *Repository[T]) auditConflicts(db *gorm.DB, s *schema.Schema, value any, columns []string) (map[string]map[string]any, error) {
+	keys := uniqueKeys(s)
+	if len(columns) > 0 {
+		fields := make([]*schema.Field, 0, len(columns))
+		for _, column := range columns {
+			if field := s.LookUpField(column); field != nil {
+				fields = append(fields, field)
+			}
+		}
+		keys = [][]*schema.Field{fields}
+	}
+
+	var conds []clause.Expression
+	for _, row := range r.rowsOf(value) {
+		rv := reflect.ValueOf(row).Elem()
+		for _, fields := range keys {
+			var eqs []clause.Expression
+			for _, field := range fields {
+				v, isZero := field.ValueOf(context.Background(), rv)
+				if v == nil || isZero && field.PrimaryKey {
+					eqs = nil
+					break
+				}
+				eqs = append(eqs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: v})
+			}
+			if len(eqs) > 0 {
+				conds = append(conds, clause.And(eqs...))
+			}
+		}
+	}
+	if len(conds) == 0 {
+		return map[string]map[string]any{}, nil
+	}
+	var rows []*T
+	err := db.Session(&gorm.Session{NewDB: true}).Model(new(T)).Unscoped().Where(clause.Or(conds...)).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshotRows(s, rows), nil
+}
+
+// 主键和唯一索引的列
+func uniqueKeys(s *schema.Schema) [][]*schema.Field {
+	indexes := s.ParseIndexes()
+	keys := [][]*schema.Field{s.PrimaryFields}
+	for _, field := range s.Fields {
+		if field.Unique && !field.PrimaryKey && field.TagSettings["UNIQUEINDEX"] == "" {
+			keys = append(keys, []*schema.Field{field})
+		}
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if index := indexes[name]; index.Class == "UNIQUE" {
+			fields := make([]*schema.Field, 0, len(index.Fields))
+			for _, option := range index.Fields {
+				fields = append(fields, option.Field)
+			}
+			keys = append(keys, fields)
+		}
+	}
+	return keys
+}
+
+// 按主键查询数据,包括已软删除的
+func (r *Repository[T]) auditRows(db *gorm.DB, s *schema.Schema, keys []any) (map[string]map[string]any, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var rows []*T
+	err := db.Session(&gorm.Session{NewDB: true}).Model(new(T)).Unscoped().
+		Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}, Values: keys}).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshotRows(s, rows), nil
+}
+
+// 对比修改前后的数据生成审计日志,更新时只记录修改的列,没有修改的记录不生成
+func (r *Repository[T]) auditLogs(ctx context.Context, before, after map[string]map[string]any) []AuditLog {
+	var t T
+	ids := make([]string, 0, len(before)+len(after))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	actor := ActorFromContext(ctx)
+	logs := make([]AuditLog, 0, len(ids))
+	for _, id := range ids {
+		old, oldOk := before[id]
+		cur, curOk := after[id]
+		log := AuditLog{Table: t.TableName(), RecordId: id, Operation: "update", Actor: actor}
+		switch {
+		case !oldOk:
+			log.Operation = "create"
+		case !curOk:
+			log.Operation = "delete"
+		default:
+			//只保留修改的列,软删除记为delete,恢复记为restore
+			changedOld, changedCur := map[string]any{}, map[string]any{}
+			for column, value := range cur {
+				if !reflect.DeepEqual(old[column], value) {
+					changedOld[column], changedCur[column] = old[column], value
+				}
+			}
+			if len(changedCur) == 0 {
+				continue
+			}
+			if column := r.deletedColumn(); column != "" {
+				if value, ok := changedCur[column]; ok {
+					log.Operation = "restore"
+					if !notDeleted(value) {
+						log.Operation = "delete"
+					}
+				}
+			}
+			old, cur = changedOld, changedCur
+		}
+		if old != nil {
+			data, _ := json.Marshal(old)
+			log.Before = string(data)
+		}
+		if cur != nil {
+			data, _ := json.Marshal(cur)
+			log.After = string(data)
+		}
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+// 写入数据中已设置的主键
+func (r *Repository[T]) primaryKeys(s *schema.Schema, value any) []any {
+	var keys []any
+	for _, row := range r.rowsOf(value) {
+		pk, isZero := s.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(row).Elem())
+		if !isZero {
+			keys = append(keys, pk)
+		}
+	}
+	return keys
+}
+
+// 按主键记录每行的值
+func snapshotRows[T any](s *schema.Schema, rows []*T) map[string]map[string]any {
+	values := make(map[string]map[string]any, len(rows))
+	for _, row := range rows {
+		rv := reflect.ValueOf(row).Elem()
+		pk, _ := s.PrioritizedPrimaryField.ValueOf(context.Background(), rv)
+		values[fmt.Sprint(pk)] = snapshot(s, rv)
+	}
+	return values
+}
+
+// 软删除的列,没有时为空
+func (r *Repository[T]) deletedColumn() string {
+	if r.softDelete.Mode != SoftDeleteNone {
+		return r.softDeleteColumn()
+	}
+	if field := r.deletedAtField(); field != nil {
+		return field.DBName
+	}
+	return ""
+}
+
+// 软删除列的值是否为未删除
+func notDeleted(value any) bool {
+	return value == nil || fmt.Sprint(value) == "0"
+}
@@ -0,0 +1,268 @@
+package gorme
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UpdateMany/BatchUpdate默认每条UPDATE语句更新的行数
+const defaultBatchUpdateSize = 500
+
+type batchConfig struct {
+	progress    func(done int, total int)
+	transaction bool
+	size        int
+}
+
+type BatchOption func(*batchConfig)
+
+// 每批执行完成后回调,done为已完成的条数,total为总条数
+func WithProgress(progress func(done int, total int)) BatchOption {
+	return func(config *batchConfig) {
+		config.progress = progress
+	}
+}
+
+// 每批的行数,用于UpdateMany/BatchUpdate,小于等于0时为默认值
+func WithBatchSize(size int) BatchOption {
+	return func(config *batchConfig) {
+		config.size = size
+	}
+}
+
+// 所有批次在同一个事务中执行,任一批失败时全部回滚
+func WithTransaction() BatchOption {
+	return func(config *batchConfig) {
+		config.transaction = true
+	}
+}
+
+// 分批插入,每批为一条多行INSERT,生成的主键回填到rows中
+// batchSize小于等于0时一次插入全部
+func (r *Repository[T]) CreateMany(rows []*T, batchSize int, opts ...BatchOption) *WriteResult {
+	config := &batchConfig{}
+	for _, o := range opts {
+		o(config)
+	}
+	total := len(rows)
+	if batchSize <= 0 {
+		batchSize = total
+	}
+	r.omitProtected()
+	r.fillRows(rows, fillCreate)
+
+	result := r.execWrite("create", rows, 0, r.audited("create", rows, func(db *gorm.DB) *gorm.DB {
+		var rowsAffected int64
+		createBatches := func(db *gorm.DB) error {
+			for i := 0; i < total; i += batchSize {
+				end := i + batchSize
+				if end > total {
+					end = total
+				}
+				tx := db.Session(&gorm.Session{}).Create(rows[i:end])
+				if tx.Error != nil {
+					return tx.Error
+				}
+				rowsAffected += tx.RowsAffected
+				if config.progress != nil {
+					config.progress(end, total)
+				}
+			}
+			return nil
+		}
+
+		tx := db.Session(&gorm.Session{})
+		if config.transaction {
+			tx.AddError(db.Transaction(createBatches))
+		} else {
+			tx.AddError(createBatches(db))
+		}
+		tx.RowsAffected = rowsAffected
+		return tx
+	}))
+	r.publish(result, Created[T]{Rows: rows, Result: result})
+	return result
+}
+
+// 按主键批量更新,每行的值可以不同,返回总影响行数
+// UPDATE `tb_order` SET `amount`=CASE `id` WHEN 1 THEN 10 WHEN 2 THEN 20 ELSE `amount` END,`updated_at`='2023-01-03 10:21:05.12' WHERE `id` IN (1,2)
+func (r *Repository[T]) UpdateMany(values map[any]Setter, opts ...BatchOption) *WriteResult {
+	return r.updateMany(values, nil, opts...)
+}
+
+// 发布的Updated事件中Rows为rows,Columns为所有Setter中的列
+func (r *Repository[T]) updateMany(values map[any]Setter, rows []*T, opts ...BatchOption) *WriteResult {
+	config := &batchConfig{size: defaultBatchUpdateSize}
+	for _, o := range opts {
+		o(config)
+	}
+	if config.size <= 0 {
+		config.size = defaultBatchUpdateSize
+	}
+	updated := map[string]any{}
+	for _, setter := range values {
+		if result := r.guardColumns(setter); result != nil {
+			return result
+		}
+		for column, value := range setter.Data {
+			updated[column] = value
+		}
+	}
+	columns := r.updatedColumns(updated)
+	sort.Strings(columns)
+	if r.filler != nil || len(r.encryptedFields()) > 0 {
+		filled := make(map[any]Setter, len(values))
+		for id, setter := range values {
+			data, err := r.encryptMap(r.fillMap(setter.Data))
+			if err != nil {
+				r.Reset()
+				return &WriteResult{Error: err}
+			}
+			filled[id] = Setter{Data: data}
+		}
+		values = filled
+	}
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	primaryKey := clause.Column{Name: s.PrioritizedPrimaryField.DBName}
+
+	//按主键排序,保证生成的SQL稳定
+	ids := make([]any, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return fmt.Sprint(ids[i]) < fmt.Sprint(ids[j])
+	})
+
+	var t T
+	total := len(ids)
+	r.prepare()
+	result := r.execWrite("update", nil, r.maxAffectedRows, func(db *gorm.DB) *gorm.DB {
+		var rowsAffected int64
+		updateBatches := func(db *gorm.DB) error {
+			for i := 0; i < total; i += config.size {
+				end := i + config.size
+				if end > total {
+					end = total
+				}
+				batch := ids[i:end]
+				assignments := caseAssignments(primaryKey, batch, values)
+				//开启审计时按每批的主键记录修改前后的数据
+				tx := r.audited("update", nil, func(db *gorm.DB) *gorm.DB {
+					return db.Model(&t).Updates(assignments)
+				})(db.Session(&gorm.Session{}).Where(clause.IN{Column: primaryKey, Values: batch}))
+				if tx.Error != nil {
+					return tx.Error
+				}
+				rowsAffected += tx.RowsAffected
+				if config.progress != nil {
+					config.progress(end, total)
+				}
+			}
+			return nil
+		}
+
+		tx := db.Session(&gorm.Session{})
+		if config.transaction {
+			tx.AddError(db.Transaction(updateBatches))
+		} else {
+			tx.AddError(updateBatches(db))
+		}
+		tx.RowsAffected = rowsAffected
+		return tx
+	})
+	r.publish(result, Updated[T]{Rows: rows, Columns: columns, Result: result})
+	return result
+}
+
+// 每列生成 CASE id WHEN ? THEN ? ... ELSE column END
+func caseAssignments(primaryKey clause.Column, ids []any, values map[any]Setter) map[string]any {
+	columnSet := map[string]bool{}
+	for _, id := range ids {
+		for column := range values[id].Data {
+			columnSet[column] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	assignments := make(map[string]any, len(columns))
+	for _, column := range columns {
+		var sql strings.Builder
+		vars := []any{primaryKey}
+		sql.WriteString("CASE ?")
+		for _, id := range ids {
+			if value, ok := values[id].Data[column]; ok {
+				sql.WriteString(" WHEN ? THEN ?")
+				vars = append(vars, id, value)
+			}
+		}
+		sql.WriteString(" ELSE ? END")
+		vars = append(vars, clause.Column{Name: column})
+		assignments[column] = gorm.Expr(sql.String(), vars...)
+	}
+	return assignments
+}
+
+// 按主键批量更新rows中指定的列,columns为空时更新除主键、创建时间、删除时间外的所有列
+func (r *Repository[T]) BatchUpdate(rows []*T, columns ...string) *WriteResult {
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+
+	var fields []*schema.Field
+	if len(columns) == 0 {
+		for _, field := range s.Fields {
+			if len(field.DBName) == 0 || field.PrimaryKey || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+				continue
+			}
+			if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+				continue
+			}
+			fields = append(fields, field)
+		}
+	} else {
+		for _, column := range columns {
+			field := s.LookUpField(column)
+			if field == nil {
+				r.Reset()
+				return &WriteResult{Error: fmt.Errorf("gorme: unknown column %s", column)}
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	ctx := context.Background()
+	values := make(map[any]Setter, len(rows))
+	updated := make([]*T, 0, len(rows))
+	for _, row := range rows {
+		rv := reflect.ValueOf(row).Elem()
+		id, isZero := s.PrioritizedPrimaryField.ValueOf(ctx, rv)
+		if isZero {
+			continue
+		}
+		updated = append(updated, row)
+		setter := r.NewSetter()
+		for _, field := range fields {
+			value, _ := field.ValueOf(ctx, rv)
+			setter = setter.Set(field.DBName, value)
+		}
+		values[id] = setter
+	}
+	return r.updateMany(values, updated)
+}
@@ -0,0 +1,118 @@
+package gorme
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// 日期条件都转为列上的范围条件,可以使用列上的索引
+// SELECT * FROM `tb_order` WHERE `created_at` >= '2023-01-03 00:00:00' AND `created_at` < '2023-01-04 00:00:00'
+func (r *Repository[T]) whereRange(column clause.Column, from, to time.Time) *Repository[T] {
+	r.DB = r.DB.Where(clause.Gte{Column: column, Value: from}).Where(clause.Lt{Column: column, Value: to})
+	return r
+}
+
+// 模型的创建时间列,没有时为created_at
+func (r *Repository[T]) createdColumn() clause.Column {
+	column := clause.Column{Table: clause.CurrentTable, Name: "created_at"}
+	if s, err := r.schema(); err == nil {
+		for _, field := range s.Fields {
+			if field.AutoCreateTime > 0 && len(field.DBName) > 0 {
+				column.Name = field.DBName
+			}
+		}
+	}
+	return column
+}
+
+func (r *Repository[T]) dateColumn(column []string) clause.Column {
+	if len(column) > 0 {
+		return clause.Column{Name: column[0]}
+	}
+	return r.createdColumn()
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// column在date所在时区的那一天
+func (r *Repository[T]) WhereDate(column string, date time.Time) *Repository[T] {
+	from := startOfDay(date)
+	return r.whereRange(clause.Column{Name: column}, from, from.AddDate(0, 0, 1))
+}
+
+// column在本地时区的某年某月
+func (r *Repository[T]) WhereMonth(column string, year int, month time.Month) *Repository[T] {
+	return r.WhereMonthIn(column, year, month, time.Local)
+}
+
+func (r *Repository[T]) WhereMonthIn(column string, year int, month time.Month, loc *time.Location) *Repository[T] {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return r.whereRange(clause.Column{Name: column}, from, from.AddDate(0, 1, 0))
+}
+
+// column在本地时区的某年
+func (r *Repository[T]) WhereYear(column string, year int) *Repository[T] {
+	return r.WhereYearIn(column, year, time.Local)
+}
+
+func (r *Repository[T]) WhereYearIn(column string, year int, loc *time.Location) *Repository[T] {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	return r.whereRange(clause.Column{Name: column}, from, from.AddDate(1, 0, 0))
+}
+
+// 按一天中的时间比较,只比较t的时分秒,op为=、!=、<>、>、>=、<、<=
+// 需要在列上取时间,不能使用列上的索引,一般与其它日期条件一起使用
+// SELECT * FROM `tb_order` WHERE TIME(`paid_at`) >= '10:00:00'
+func (r *Repository[T]) WhereTime(column, op string, t time.Time) *Repository[T] {
+	op = strings.TrimSpace(op)
+	switch op {
+	case "=", "!=", "<>", ">", ">=", "<", "<=":
+		sql := "TIME(?) " + op + " ?"
+		switch r.DB.Dialector.Name() {
+		case "postgres":
+			sql = "CAST(? AS TIME) " + op + " CAST(? AS TIME)"
+		case "sqlite":
+			sql = "time(?) " + op + " ?"
+		}
+		r.DB = r.DB.Where(clause.Expr{SQL: sql, Vars: []any{clause.Column{Name: column}, t.Format("15:04:05")}})
+	default:
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: unsupported operator %s for WhereTime", op))
+	}
+	return r
+}
+
+// 创建时间在[from, to)之间
+func (r *Repository[T]) CreatedBetween(from, to time.Time) *Repository[T] {
+	return r.whereRange(r.createdColumn(), from, to)
+}
+
+// 今天(本地时区)的数据,column为空时为创建时间列
+func (r *Repository[T]) Today(column ...string) *Repository[T] {
+	return r.TodayIn(time.Local, column...)
+}
+
+func (r *Repository[T]) TodayIn(loc *time.Location, column ...string) *Repository[T] {
+	today := startOfDay(time.Now().In(loc))
+	return r.whereRange(r.dateColumn(column), today, today.AddDate(0, 0, 1))
+}
+
+// 最近n天(本地时区,含今天)的数据,column为空时为创建时间列,n小于1时返回错误
+func (r *Repository[T]) LastNDays(n int, column ...string) *Repository[T] {
+	return r.LastNDaysIn(n, time.Local, column...)
+}
+
+func (r *Repository[T]) LastNDaysIn(n int, loc *time.Location, column ...string) *Repository[T] {
+	if n <= 0 {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: LastNDays requires n > 0, got %d", n))
+		return r
+	}
+	today := startOfDay(time.Now().In(loc))
+	return r.whereRange(r.dateColumn(column), today.AddDate(0, 0, 1-n), today.AddDate(0, 0, 1))
+}
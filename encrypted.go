@@ -0,0 +1,563 @@
+package gorme
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 没有设置密钥时加解密返回的错误
+var ErrNoKeyProvider = errors.New("gorme: key provider not set, use SetKeyProvider")
+
+// 加密列的密钥来源,按编号保留旧密钥以解密轮换前的数据
+type KeyProvider interface {
+	//当前用于加密的密钥及其编号
+	CurrentKey() (id string, key []byte, err error)
+	//按编号取密钥,用于解密
+	Key(id string) ([]byte, error)
+	//盲索引的HMAC密钥,轮换加密密钥时不能改变
+	IndexKey() ([]byte, error)
+}
+
+// 内存中的密钥,Keys的值为16、24或32字节的AES密钥
+type Keyring struct {
+	Current string
+	Keys    map[string][]byte
+	Index   []byte
+}
+
+func (k *Keyring) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k *Keyring) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("gorme: unknown encryption key %s", id)
+	}
+	return key, nil
+}
+
+func (k *Keyring) IndexKey() ([]byte, error) {
+	if len(k.Index) == 0 {
+		return nil, errors.New("gorme: blind index key not set")
+	}
+	return k.Index, nil
+}
+
+var (
+	keyProvider   KeyProvider
+	keyProviderMu sync.RWMutex
+)
+
+// 设置加密列使用的密钥
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = provider
+}
+
+func currentKeyProvider() (KeyProvider, error) {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	if keyProvider == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return keyProvider, nil
+}
+
+// 加密存储的列,写入时用AES-GCM加密为"密钥编号:base64(nonce+密文)",读取时解密
+// 可以配合`gorme:"blind_index:列名"`的索引列按等值查询
+type Encrypted[V any] struct {
+	Data V
+}
+
+func NewEncrypted[V any](data V) Encrypted[V] {
+	return Encrypted[V]{Data: data}
+}
+
+func (e Encrypted[V]) Value() (driver.Value, error) {
+	plain, err := e.plaintext()
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(plain)
+}
+
+func (e *Encrypted[V]) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		var zero V
+		e.Data = zero
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("gorme: cannot scan %T into Encrypted", src)
+	}
+	plain, err := decrypt(text)
+	if err != nil {
+		return err
+	}
+	return e.setPlaintext(plain)
+}
+
+func (e Encrypted[V]) GormDataType() string {
+	return "string"
+}
+
+func (e Encrypted[V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Data)
+}
+
+func (e *Encrypted[V]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &e.Data)
+}
+
+func (e Encrypted[V]) plaintext() ([]byte, error) {
+	return encodePlain(e.Data)
+}
+
+func (e *Encrypted[V]) setPlaintext(plain []byte) error {
+	switch data := any(&e.Data).(type) {
+	case *string:
+		*data = string(plain)
+	case *[]byte:
+		*data = plain
+	default:
+		return json.Unmarshal(plain, &e.Data)
+	}
+	return nil
+}
+
+// 把明文值赋给Data,value不是V类型时返回错误
+func (e *Encrypted[V]) setData(value any) error {
+	data, ok := value.(V)
+	if !ok {
+		return fmt.Errorf("gorme: cannot use %T as %T", value, e.Data)
+	}
+	e.Data = data
+	return nil
+}
+
+type encryptedValue interface {
+	plaintext() ([]byte, error)
+}
+
+// 字符串、字节按原样加密,其它类型按JSON加密
+func encodePlain(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case encryptedValue:
+		return v.plaintext()
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	}
+	return json.Marshal(value)
+}
+
+func encrypt(plain []byte) (string, error) {
+	provider, err := currentKeyProvider()
+	if err != nil {
+		return "", err
+	}
+	id, key, err := provider.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	//密钥编号作为附加数据,防止密文被换到其它密钥下
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(id))
+	return id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(text string) ([]byte, error) {
+	index := strings.LastIndex(text, ":")
+	if index < 0 {
+		return nil, errors.New("gorme: invalid encrypted value")
+	}
+	id := text[:index]
+	sealed, err := base64.StdEncoding.DecodeString(text[index+1:])
+	if err != nil {
+		return nil, fmt.Errorf("gorme: invalid encrypted value: %w", err)
+	}
+	provider, err := currentKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	key, err := provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("gorme: invalid encrypted value")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(id))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 计算值的盲索引,HMAC-SHA256的十六进制
+func BlindIndex(value any) (string, error) {
+	plain, err := encodePlain(value)
+	if err != nil {
+		return "", err
+	}
+	provider, err := currentKeyProvider()
+	if err != nil {
+		return "", err
+	}
+	key, err := provider.IndexKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(plain)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// 加密列到盲索引列的缓存
+var blindIndexCache = &sync.Map{}
+
+// 模型中加密列到标签为`gorme:"blind_index:列名"`的索引列的映射
+func (r *Repository[T]) blindIndexes() map[string]*schema.Field {
+	var t T
+	typ := reflect.TypeOf(t)
+	if v, ok := blindIndexCache.Load(typ); ok {
+		return v.(map[string]*schema.Field)
+	}
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+
+	indexes := map[string]*schema.Field{}
+	for _, field := range s.Fields {
+		tag, ok := field.Tag.Lookup("gorme")
+		if !ok || len(field.DBName) == 0 {
+			continue
+		}
+		for _, setting := range strings.Split(tag, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(setting), ":")
+			if found && key == "blind_index" {
+				if source := s.LookUpField(value); source != nil {
+					indexes[source.DBName] = field
+				}
+			}
+		}
+	}
+	blindIndexCache.Store(typ, indexes)
+	return indexes
+}
+
+// 模型中Encrypted类型的字段
+func (r *Repository[T]) encryptedFields() []*schema.Field {
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if len(field.DBName) > 0 && isEncrypted(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func isEncrypted(field *schema.Field) bool {
+	typ := field.FieldType
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return reflect.PtrTo(typ).Implements(reflect.TypeOf((*encryptedValue)(nil)).Elem())
+}
+
+// 写入前按加密列计算盲索引列,all为false时只处理非0值的加密列
+func (r *Repository[T]) blindIndexRows(rows []*T, all bool) error {
+	indexes := r.blindIndexes()
+	if len(indexes) == 0 || len(rows) == 0 {
+		return nil
+	}
+	s, err := r.schema()
+	if err != nil {
+		return err
+	}
+	ctx := r.DB.Statement.Context
+	for _, row := range rows {
+		rv := reflect.ValueOf(row).Elem()
+		for column, index := range indexes {
+			value, isZero := s.LookUpField(column).ValueOf(ctx, rv)
+			if isZero && !all {
+				continue
+			}
+			var digest any = reflect.Zero(index.FieldType).Interface()
+			if e, ok := value.(encryptedValue); ok && !(isZero && reflect.ValueOf(value).Kind() == reflect.Ptr) {
+				if digest, err = BlindIndex(e); err != nil {
+					return err
+				}
+			}
+			if err = index.Set(ctx, rv, digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 按map更新时把加密列的明文包装为Encrypted,并一起更新盲索引列,返回新的map
+func (r *Repository[T]) encryptMap(data map[string]any) (map[string]any, error) {
+	fields := r.encryptedFields()
+	if len(fields) == 0 {
+		return data, nil
+	}
+	indexes := r.blindIndexes()
+	result := make(map[string]any, len(data))
+	for column, value := range data {
+		result[column] = value
+	}
+	s, _ := r.schema()
+	for column, value := range data {
+		field := s.LookUpField(column)
+		if field == nil || !isEncrypted(field) {
+			continue
+		}
+		if _, ok := value.(clause.Expression); ok {
+			continue
+		}
+		if value != nil {
+			if _, ok := value.(encryptedValue); !ok {
+				typ := field.FieldType
+				for typ.Kind() == reflect.Ptr {
+					typ = typ.Elem()
+				}
+				encrypted := reflect.New(typ)
+				if err := encrypted.Interface().(interface{ setData(any) error }).setData(value); err != nil {
+					return nil, err
+				}
+				value = encrypted.Elem().Interface()
+				result[column] = value
+			}
+		}
+		if index, ok := indexes[field.DBName]; ok {
+			if value == nil {
+				result[index.DBName] = nil
+				continue
+			}
+			digest, err := BlindIndex(value)
+			if err != nil {
+				return nil, err
+			}
+			result[index.DBName] = digest
+		}
+	}
+	return result, nil
+}
+
+// 按加密列查询时改为按盲索引列查询,column不是有盲索引的加密列时ok为false
+func (r *Repository[T]) blindIndexColumn(column string) (string, bool) {
+	indexes := r.blindIndexes()
+	if len(indexes) == 0 {
+		return "", false
+	}
+	prefix, name := "", strings.TrimSpace(column)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		prefix, name = name[:i+1], name[i+1:]
+	}
+	index, ok := indexes[strings.Trim(name, "`\"")]
+	if !ok {
+		return "", false
+	}
+	return prefix + index.DBName, true
+}
+
+// 盲索引只能做等值比较,LIKE、>等操作返回错误,IN使用WhereIn
+func blindIndexOperator(column, op string) error {
+	switch strings.TrimSpace(op) {
+	case "=", "!=", "<>":
+		return nil
+	}
+	return fmt.Errorf("gorme: operator %s is not supported on encrypted column %s", op, column)
+}
+
+// 更新有Select时,选中的加密列的盲索引列一起更新
+func (r *Repository[T]) selectBlindIndexes() {
+	selects := r.DB.Statement.Selects
+	if len(selects) == 0 || containsString(selects, "*") {
+		return
+	}
+	indexes := r.blindIndexes()
+	if len(indexes) == 0 {
+		return
+	}
+	s, err := r.schema()
+	if err != nil {
+		return
+	}
+	added := append([]string{}, selects...)
+	for _, name := range selects {
+		field := s.LookUpField(name)
+		if field == nil {
+			continue
+		}
+		if index, ok := indexes[field.DBName]; ok && !containsString(added, index.DBName) && !containsString(added, index.Name) {
+			added = append(added, index.DBName)
+		}
+	}
+	if len(added) > len(selects) {
+		r.DB = r.DB.Select(added)
+	}
+}
+
+// 条件中的列为加密列时改为盲索引列和盲索引值,操作不支持时记录错误并返回false
+func (r *Repository[T]) blindIndexCondition(column, op string, value any) (string, any, bool) {
+	index, ok := r.blindIndexColumn(column)
+	if !ok {
+		return column, value, true
+	}
+	if err := blindIndexOperator(column, op); err != nil {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(err)
+		return column, value, false
+	}
+	return index, r.blindIndexValue(value), true
+}
+
+// 查询值转换为盲索引,values为切片时逐个转换
+func (r *Repository[T]) blindIndexValues(values any) any {
+	rv := reflect.ValueOf(values)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		digests := make([]string, rv.Len())
+		for i := range digests {
+			digests[i], _ = r.blindIndexValue(rv.Index(i).Interface()).(string)
+		}
+		return digests
+	}
+	return r.blindIndexValue(values)
+}
+
+func (r *Repository[T]) blindIndexValue(value any) any {
+	digest, err := BlindIndex(value)
+	if err != nil {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(err)
+	}
+	return digest
+}
+
+// 用当前密钥重新加密密文不是当前密钥的数据,返回重新加密的行数
+// 轮换密钥时先把新密钥设为当前密钥,旧密钥保留在KeyProvider中,执行完后再删除旧密钥
+func (r *Repository[T]) RotateKeys(batchSize int) *WriteResult {
+	s, err := r.schemaWithPrimaryKey()
+	var current string
+	if err == nil {
+		var provider KeyProvider
+		if provider, err = currentKeyProvider(); err == nil {
+			current, _, err = provider.CurrentKey()
+		}
+	}
+	if err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	fields := r.encryptedFields()
+	if len(fields) == 0 {
+		r.Reset()
+		return &WriteResult{}
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchUpdateSize
+	}
+
+	var columns []string
+	var stale []clause.Expression
+	for _, field := range fields {
+		columns = append(columns, field.DBName)
+		column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+		stale = append(stale, clause.Expr{SQL: "? NOT LIKE ?", Vars: []any{column, current + ":%"}})
+	}
+	primaryKey := clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}
+
+	r.prepare()
+	result := r.execWrite("update", nil, 0, func(db *gorm.DB) *gorm.DB {
+		var rowsAffected int64
+		var last any
+		err := func() error {
+			for {
+				query := db.Session(&gorm.Session{}).Where(clause.Or(stale...))
+				if last != nil {
+					query = query.Where(clause.Gt{Column: primaryKey, Value: last})
+				}
+				var rows []*T
+				if err := query.Order(clause.OrderByColumn{Column: primaryKey}).Limit(batchSize).Find(&rows).Error; err != nil {
+					return err
+				}
+				//开启审计时按每批的主键记录,明文不变的行不生成审计日志
+				tx := r.audited("update", rows, func(db *gorm.DB) *gorm.DB {
+					tx := db.Session(&gorm.Session{})
+					for _, row := range rows {
+						update := db.Session(&gorm.Session{NewDB: true}).Model(row).Select(columns).UpdateColumns(row)
+						if update.Error != nil {
+							tx.AddError(update.Error)
+							return tx
+						}
+						tx.RowsAffected += update.RowsAffected
+					}
+					return tx
+				})(db)
+				if tx.Error != nil {
+					return tx.Error
+				}
+				rowsAffected += tx.RowsAffected
+				if len(rows) < batchSize {
+					return nil
+				}
+				last, _ = s.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(rows[len(rows)-1]).Elem())
+			}
+		}()
+		tx := db.Session(&gorm.Session{})
+		tx.AddError(err)
+		tx.RowsAffected = rowsAffected
+		return tx
+	})
+	r.publish(result, Updated[T]{Columns: columns, Result: result})
+	return result
+}
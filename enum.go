@@ -0,0 +1,199 @@
+package gorme
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// 枚举类型到存储值的映射
+var enumMappings = &sync.Map{}
+
+type enumMapping struct {
+	values  map[any]any //Go常量到存储值
+	reverse map[string]any
+}
+
+// 定义枚举类型E的常量与存储值(字符串或整数)的映射,写入未定义的值时返回*EnumError
+//
+//	gorme.DefineEnum(map[OrderStatus]any{StatusPaid: "paid", StatusShipped: "shipped"})
+func DefineEnum[E comparable](values map[E]any) {
+	mapping := enumMapping{values: map[any]any{}, reverse: map[string]any{}}
+	for constant, stored := range values {
+		mapping.values[constant] = stored
+		mapping.reverse[fmt.Sprint(stored)] = constant
+	}
+	var e E
+	enumMappings.Store(reflect.TypeOf(e), mapping)
+}
+
+func lookupEnum[E comparable]() (enumMapping, bool) {
+	var e E
+	mapping, ok := enumMappings.Load(reflect.TypeOf(e))
+	if !ok {
+		return enumMapping{}, false
+	}
+	return mapping.(enumMapping), true
+}
+
+// 枚举值不在定义中
+type EnumError struct {
+	Type  string
+	Value any
+}
+
+func (e *EnumError) Error() string {
+	return fmt.Sprintf("gorme: invalid value %v for enum %s", e.Value, e.Type)
+}
+
+// 枚举列,按DefineEnum的映射存储,没有定义映射时按E的字符串或整数值存储
+type Enum[E comparable] struct {
+	Data E
+}
+
+func NewEnum[E comparable](data E) Enum[E] {
+	return Enum[E]{Data: data}
+}
+
+// 是否为定义过的值
+func (e Enum[E]) Valid() bool {
+	_, err := e.stored()
+	return err == nil
+}
+
+func (e Enum[E]) stored() (any, error) {
+	mapping, ok := lookupEnum[E]()
+	if !ok {
+		rv := reflect.ValueOf(e.Data)
+		switch rv.Kind() {
+		case reflect.String:
+			return rv.String(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(rv.Uint()), nil
+		}
+		return nil, &EnumError{Type: reflect.TypeOf(e.Data).String(), Value: e.Data}
+	}
+	stored, ok := mapping.values[e.Data]
+	if !ok {
+		return nil, &EnumError{Type: reflect.TypeOf(e.Data).String(), Value: e.Data}
+	}
+	return stored, nil
+}
+
+// 未定义的0值存为NULL
+func (e Enum[E]) Value() (driver.Value, error) {
+	var zero E
+	stored, err := e.stored()
+	if err != nil && e.Data == zero {
+		return nil, nil
+	}
+	return stored, err
+}
+
+func (e *Enum[E]) Scan(src any) error {
+	if src == nil {
+		var zero E
+		e.Data = zero
+		return nil
+	}
+	if b, ok := src.([]byte); ok {
+		src = string(b)
+	}
+	mapping, ok := lookupEnum[E]()
+	if ok {
+		constant, found := mapping.reverse[fmt.Sprint(src)]
+		if !found {
+			return &EnumError{Type: reflect.TypeOf(e.Data).String(), Value: src}
+		}
+		e.Data = constant.(E)
+		return nil
+	}
+
+	rv := reflect.ValueOf(&e.Data).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(fmt.Sprint(src))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, err := fmt.Sscan(fmt.Sprint(src), rv.Addr().Interface())
+		return err
+	}
+	return fmt.Errorf("gorme: cannot scan %T into Enum", src)
+}
+
+// JSON中使用存储值
+func (e Enum[E]) MarshalJSON() ([]byte, error) {
+	stored, err := e.stored()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stored)
+}
+
+func (e *Enum[E]) UnmarshalJSON(data []byte) error {
+	var stored any
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	if f, ok := stored.(float64); ok {
+		stored = int64(f)
+	}
+	return e.Scan(stored)
+}
+
+type enumValue interface {
+	stored() (any, error)
+}
+
+// 写入前检查模型中的枚举列是否为定义过的值
+func (r *Repository[T]) validateEnums(rows []*T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	s, err := r.schema()
+	if err != nil {
+		return err
+	}
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 || !reflect.PtrTo(field.FieldType).Implements(reflect.TypeOf((*enumValue)(nil)).Elem()) {
+			continue
+		}
+		for _, row := range rows {
+			value, isZero := field.ValueOf(r.DB.Statement.Context, reflect.ValueOf(row).Elem())
+			if isZero {
+				continue
+			}
+			if _, err = value.(enumValue).stored(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 按map或Setter更新时检查枚举值
+func validateEnumMap(values any) error {
+	if setter, ok := values.(Setter); ok {
+		values = setter.Data
+	}
+	data, ok := values.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, value := range data {
+		if _, ok := value.(enumValue); !ok {
+			continue
+		}
+		if valuer, ok := value.(driver.Valuer); ok {
+			if _, err := valuer.Value(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
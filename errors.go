@@ -0,0 +1,213 @@
+package gorme
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 数据库错误分类,可用errors.Is(err, gorme.ErrDuplicateKey)判断
+var (
+	ErrDuplicateKey = errors.New("gorme: duplicate key")
+	ErrForeignKey   = errors.New("gorme: foreign key violation")
+	ErrNotNull      = errors.New("gorme: not null violation")
+	ErrCheck        = errors.New("gorme: check constraint violation")
+	ErrDeadlock     = errors.New("gorme: deadlock")
+	ErrLockTimeout  = errors.New("gorme: lock wait timeout")
+)
+
+// 分类后的数据库错误
+type DBError struct {
+	Kind       error  //错误分类,如ErrDuplicateKey
+	Constraint string //约束或索引名,驱动提供时才有值
+	Column     string //列名,驱动提供时才有值
+	Err        error  //驱动返回的原始错误
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// 同时兼容gorm的ErrDuplicatedKey和ErrForeignKeyViolated
+func (e *DBError) Is(target error) bool {
+	switch target {
+	case e.Kind:
+		return true
+	case gorm.ErrDuplicatedKey:
+		return e.Kind == ErrDuplicateKey
+	case gorm.ErrForeignKeyViolated:
+		return e.Kind == ErrForeignKey
+	}
+	return false
+}
+
+// 把驱动错误转换为DBError,无法识别时返回nil
+type ErrorTranslator func(err error) *DBError
+
+var errorTranslators sync.Map
+
+func init() {
+	RegisterErrorTranslator("mysql", translateMySQLError)
+	RegisterErrorTranslator("postgres", translatePostgresError)
+	RegisterErrorTranslator("sqlite", translateSQLiteError)
+}
+
+// 为gorm方言(Dialector.Name())注册错误转换器,可覆盖内置的转换器
+func RegisterErrorTranslator(dialect string, translator ErrorTranslator) {
+	errorTranslators.Store(dialect, translator)
+}
+
+// 按当前方言转换错误,无法识别的错误原样返回
+func (r *Repository[T]) translateError(err error) error {
+	if err == nil || r.DB == nil {
+		return err
+	}
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	translator, ok := errorTranslators.Load(r.DB.Dialector.Name())
+	if !ok {
+		return err
+	}
+	if dbErr = translator.(ErrorTranslator)(err); dbErr != nil {
+		return dbErr
+	}
+	return err
+}
+
+var (
+	mysqlDuplicate  = regexp.MustCompile("Duplicate entry '.*' for key '(.+)'")
+	mysqlForeignKey = regexp.MustCompile("CONSTRAINT `(.+?)` FOREIGN KEY \\(`(.+?)`\\)")
+	mysqlNotNull    = regexp.MustCompile("(?:Column|Field) '(.+?)'")
+	mysqlCheck      = regexp.MustCompile("Check constraint '(.+?)'")
+)
+
+func translateMySQLError(err error) *DBError {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return nil
+	}
+	dbErr := &DBError{Err: err}
+	switch mysqlErr.Number {
+	case 1062:
+		dbErr.Kind = ErrDuplicateKey
+		if m := mysqlDuplicate.FindStringSubmatch(mysqlErr.Message); m != nil {
+			//MySQL8中为 表名.索引名
+			dbErr.Constraint = m[1][strings.LastIndex(m[1], ".")+1:]
+		}
+	case 1451, 1452:
+		dbErr.Kind = ErrForeignKey
+		if m := mysqlForeignKey.FindStringSubmatch(mysqlErr.Message); m != nil {
+			dbErr.Constraint, dbErr.Column = m[1], m[2]
+		}
+	case 1048, 1364:
+		dbErr.Kind = ErrNotNull
+		if m := mysqlNotNull.FindStringSubmatch(mysqlErr.Message); m != nil {
+			dbErr.Column = m[1]
+		}
+	case 3819:
+		dbErr.Kind = ErrCheck
+		if m := mysqlCheck.FindStringSubmatch(mysqlErr.Message); m != nil {
+			dbErr.Constraint = m[1]
+		}
+	case 1213:
+		dbErr.Kind = ErrDeadlock
+	case 1205, 3572:
+		dbErr.Kind = ErrLockTimeout
+	default:
+		return nil
+	}
+	return dbErr
+}
+
+// 兼容pgx的*pgconn.PgError和lib/pq的*pq.Error,都实现了SQLState()
+func translatePostgresError(err error) *DBError {
+	var pgErr interface{ SQLState() string }
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+	dbErr := &DBError{Err: err}
+	switch pgErr.SQLState() {
+	case "23505":
+		dbErr.Kind = ErrDuplicateKey
+	case "23503":
+		dbErr.Kind = ErrForeignKey
+	case "23502":
+		dbErr.Kind = ErrNotNull
+	case "23514":
+		dbErr.Kind = ErrCheck
+	case "40P01":
+		dbErr.Kind = ErrDeadlock
+	case "55P03":
+		dbErr.Kind = ErrLockTimeout
+	default:
+		return nil
+	}
+	dbErr.Constraint = stringField(pgErr, "ConstraintName", "Constraint")
+	dbErr.Column = stringField(pgErr, "ColumnName", "Column")
+	return dbErr
+}
+
+// 读取结构体中第一个存在的字符串字段
+func stringField(value any, names ...string) string {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if rv.Kind() != reflect.Struct {
+		return ""
+	}
+	for _, name := range names {
+		if field := rv.FieldByName(name); field.IsValid() && field.Kind() == reflect.String {
+			return field.String()
+		}
+	}
+	return ""
+}
+
+var sqliteConstraint = regexp.MustCompile(`(UNIQUE|FOREIGN KEY|NOT NULL|CHECK) constraint failed(?:: (.+))?`)
+
+// sqlite各驱动的错误类型不同,按错误信息识别
+func translateSQLiteError(err error) *DBError {
+	message := err.Error()
+	if strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked") {
+		return &DBError{Kind: ErrLockTimeout, Err: err}
+	}
+	m := sqliteConstraint.FindStringSubmatch(message)
+	if m == nil {
+		return nil
+	}
+	dbErr := &DBError{Err: err}
+	switch m[1] {
+	case "UNIQUE":
+		dbErr.Kind = ErrDuplicateKey
+		dbErr.Column = sqliteColumns(m[2])
+	case "FOREIGN KEY":
+		dbErr.Kind = ErrForeignKey
+	case "NOT NULL":
+		dbErr.Kind = ErrNotNull
+		dbErr.Column = sqliteColumns(m[2])
+	case "CHECK":
+		dbErr.Kind = ErrCheck
+		dbErr.Constraint = m[2]
+	}
+	return dbErr
+}
+
+// "tb_order.user_id, tb_order.goods_name" => "user_id,goods_name"
+func sqliteColumns(columns string) string {
+	var names []string
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if len(column) > 0 {
+			names = append(names, column[strings.LastIndex(column, ".")+1:])
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,250 @@
+package gorme
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"reflect"
+	"sync"
+)
+
+// 通过仓库写入后的事件,Rows为写入的数据,按条件更新/删除时为空
+type Created[T Model] struct {
+	Rows   []*T
+	Result *WriteResult
+}
+
+type Updated[T Model] struct {
+	Rows    []*T
+	Columns []string //更新的列,不含自动更新的时间列
+	Result  *WriteResult
+}
+
+type Deleted[T Model] struct {
+	Soft   bool //是否为软删除
+	Result *WriteResult
+}
+
+type Restored[T Model] struct {
+	Result *WriteResult
+}
+
+// 事件总线,未调用SetEventBus的仓库使用全局的Events
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[reflect.Type][]eventHandler
+}
+
+type eventHandler struct {
+	afterCommit bool
+	handle      func(ctx context.Context, event any)
+}
+
+// 全局事件总线
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: map[reflect.Type][]eventHandler{}}
+}
+
+// 订阅事件,写入成功后同步调用,在事务中写入时也立即调用
+// gorme.Subscribe(gorme.Events, func(ctx context.Context, e gorme.Created[OrderModel]) {...})
+func Subscribe[E any](bus *EventBus, handler func(ctx context.Context, event E)) {
+	bus.subscribe(handler, false)
+}
+
+// 订阅事件,在事务中写入时等事务提交后再调用,回滚时不调用
+// 须通过仓库的Begin或Transaction开启事务,其它方式开启的事务中写入时立即调用
+func SubscribeAfterCommit[E any](bus *EventBus, handler func(ctx context.Context, event E)) {
+	bus.subscribe(handler, true)
+}
+
+func (bus *EventBus) subscribe(handler any, afterCommit bool) {
+	fn := reflect.ValueOf(handler)
+	eventType := fn.Type().In(1)
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers[eventType] = append(bus.handlers[eventType], eventHandler{
+		afterCommit: afterCommit,
+		handle: func(ctx context.Context, event any) {
+			fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(event)})
+		},
+	})
+}
+
+// 发布事件,queue不为空时afterCommit的订阅者等事务提交后调用
+func (bus *EventBus) publish(ctx context.Context, queue *afterCommitQueue, event any) {
+	bus.mu.RLock()
+	handlers := bus.handlers[reflect.TypeOf(event)]
+	bus.mu.RUnlock()
+
+	for _, h := range handlers {
+		if h.afterCommit && queue != nil {
+			h := h
+			queue.add(func() { h.handle(ctx, event) })
+			continue
+		}
+		h.handle(ctx, event)
+	}
+}
+
+const afterCommitKey = "gorme:after_commit"
+
+// 事务中待提交后调用的订阅者,保存在事务的Statement中,事务结束后随事务释放
+type afterCommitQueue struct {
+	sync.Mutex
+	calls []func()
+}
+
+// 在事务上挂载等待队列,返回可以重复使用的会话
+func withAfterCommit(tx *gorm.DB) (*gorm.DB, *afterCommitQueue) {
+	queue := &afterCommitQueue{}
+	return tx.Set(afterCommitKey, queue).Session(&gorm.Session{}), queue
+}
+
+// 事务的等待队列,不是通过仓库开启的事务返回nil
+func afterCommitOf(db *gorm.DB) *afterCommitQueue {
+	if v, ok := db.Get(afterCommitKey); ok {
+		return v.(*afterCommitQueue)
+	}
+	return nil
+}
+
+func (q *afterCommitQueue) add(call func()) {
+	q.Lock()
+	defer q.Unlock()
+	q.calls = append(q.calls, call)
+}
+
+// 事务结束,提交时调用等待的订阅者,回滚时丢弃
+func (q *afterCommitQueue) finish(committed bool) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	calls := q.calls
+	q.calls = nil
+	q.Unlock()
+	if !committed {
+		return
+	}
+	for _, call := range calls {
+		call()
+	}
+}
+
+// 设置仓库的事件总线
+func (r *Repository[T]) SetEventBus(bus *EventBus) *Repository[T] {
+	r.events = bus
+	return r
+}
+
+// 写入成功时发布事件
+func (r *Repository[T]) publish(result *WriteResult, event any) {
+	if result.Error != nil {
+		return
+	}
+	bus := r.events
+	if bus == nil {
+		bus = Events
+	}
+	ctx := result.ctx
+	if ctx == nil {
+		ctx = r.DB.Statement.Context
+	}
+	bus.publish(ctx, afterCommitOf(r.DB), event)
+}
+
+// 把写入的数据转成[]*T,支持T、*T、[]T、[]*T
+func (r *Repository[T]) rowsOf(value any) []*T {
+	switch v := value.(type) {
+	case *T:
+		return []*T{v}
+	case T:
+		return []*T{&v}
+	case []*T:
+		return v
+	case *[]*T:
+		return *v
+	case []T:
+		rows := make([]*T, len(v))
+		for i := range v {
+			rows[i] = &v[i]
+		}
+		return rows
+	case *[]T:
+		return r.rowsOf(*v)
+	}
+	return nil
+}
+
+// 更新的列,values为map、Setter或模型,模型取Select的列或非0值的列
+func (r *Repository[T]) updatedColumns(values any) []string {
+	if setter, ok := values.(Setter); ok {
+		values = setter.Data
+	}
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	column := func(name string) string {
+		if field := s.LookUpField(name); field != nil && len(field.DBName) > 0 {
+			return field.DBName
+		}
+		return name
+	}
+
+	var columns []string
+	if data, ok := values.(map[string]any); ok {
+		for name := range data {
+			columns = append(columns, column(name))
+		}
+		return columns
+	}
+	if len(r.DB.Statement.Selects) > 0 {
+		for _, name := range r.DB.Statement.Selects {
+			if name != "*" {
+				columns = append(columns, column(name))
+			}
+		}
+		if len(columns) > 0 {
+			return columns
+		}
+	}
+	rows := r.rowsOf(values)
+	if len(rows) == 0 {
+		return nil
+	}
+	rv := reflect.ValueOf(rows[0]).Elem()
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 || field.PrimaryKey || field.AutoUpdateTime > 0 {
+			continue
+		}
+		if _, isZero := field.ValueOf(r.DB.Statement.Context, rv); !isZero {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// Save更新的列,有Select时为Select的列,否则为主键、自动更新时间外的所有列
+func (r *Repository[T]) savedColumns() []string {
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	var columns []string
+	for _, name := range r.DB.Statement.Selects {
+		if field := s.LookUpField(name); field != nil && len(field.DBName) > 0 {
+			columns = append(columns, field.DBName)
+		}
+	}
+	if len(columns) > 0 {
+		return columns
+	}
+	for _, field := range s.Fields {
+		if len(field.DBName) > 0 && !field.PrimaryKey && field.AutoUpdateTime == 0 {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
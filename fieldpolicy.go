@@ -0,0 +1,390 @@
+package gorme
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type FieldAccess int
+
+const (
+	FieldVisible  FieldAccess = iota //可见可写
+	FieldReadOnly                    //可见不可写
+	FieldMasked                      //查询后脱敏,不可写
+	FieldHidden                      //不查询,不可写
+)
+
+// 列的访问策略,Access按context返回访问级别
+type FieldPolicy struct {
+	Column string
+	Access func(ctx context.Context) FieldAccess
+	Mask   func(value string) string //脱敏方法,为空时为Mask(3, 4)
+}
+
+// 不可写的列
+type FieldAccessError struct {
+	Columns []string
+}
+
+func (e *FieldAccessError) Error() string {
+	return fmt.Sprintf("gorme: columns %s are not writable", strings.Join(e.Columns, ","))
+}
+
+// 保留前keepStart个和后keepEnd个字符,中间替换为*,如138****1234
+func Mask(keepStart, keepEnd int) func(value string) string {
+	return func(value string) string {
+		runes := []rune(value)
+		if len(runes) <= keepStart+keepEnd {
+			return strings.Repeat("*", utf8.RuneCountInString(value))
+		}
+		return string(runes[:keepStart]) + strings.Repeat("*", len(runes)-keepStart-keepEnd) + string(runes[len(runes)-keepEnd:])
+	}
+}
+
+// 设置列的访问策略
+// 隐藏的列不查询,脱敏的列查询后替换值;按map、Setter更新不可写的列时返回*FieldAccessError,按模型Save/Updates时忽略这些列
+func (r *Repository[T]) SetFieldPolicy(policies ...FieldPolicy) *Repository[T] {
+	r.fieldPolicies = policies
+	return r
+}
+
+type columnAccess struct {
+	level FieldAccess
+	mask  func(value string) string
+}
+
+// 按当前context计算每列的访问级别,只返回非FieldVisible的列
+func (r *Repository[T]) fieldAccess() map[string]columnAccess {
+	if len(r.fieldPolicies) == 0 {
+		return nil
+	}
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	access := map[string]columnAccess{}
+	for _, policy := range r.fieldPolicies {
+		level := policy.Access(r.DB.Statement.Context)
+		if level == FieldVisible {
+			continue
+		}
+		column := policy.Column
+		if field := s.LookUpField(column); field != nil && len(field.DBName) > 0 {
+			column = field.DBName
+		}
+		mask := policy.Mask
+		if mask == nil {
+			mask = Mask(3, 4)
+		}
+		access[column] = columnAccess{level: level, mask: mask}
+	}
+	return access
+}
+
+// 查询时去掉隐藏的列,Row/Rows返回原始数据无法脱敏,脱敏的列同样去掉
+// Select的表达式中引用了隐藏或脱敏的列时返回错误,如 CONCAT(phone,'') AS p、MAX(salary)
+func (r *Repository[T]) applyFieldPolicies() {
+	var hidden, protected []string
+	for column, policy := range r.fieldAccess() {
+		if policy.level == FieldHidden || policy.level == FieldMasked && r.state.rawRows {
+			hidden = append(hidden, column)
+		}
+		if policy.level == FieldHidden || policy.level == FieldMasked {
+			protected = append(protected, column)
+		}
+	}
+	if len(protected) == 0 {
+		return
+	}
+	if column, ok := r.selectExprColumn(protected); ok {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: column %s cannot be used in a select expression", column))
+		return
+	}
+	if len(hidden) == 0 {
+		return
+	}
+	if len(r.DB.Statement.Selects) == 0 {
+		r.DB = r.DB.Omit(append(append([]string{}, r.DB.Statement.Omits...), hidden...)...)
+		return
+	}
+
+	selects := make([]string, 0, len(r.DB.Statement.Selects))
+	for _, name := range r.DB.Statement.Selects {
+		if !containsString(hidden, bareColumn(name)) {
+			selects = append(selects, name)
+		}
+	}
+	r.DB = r.DB.Select(selects)
+}
+
+// 去掉表名和引号的列名,如 `tb_user`.`phone` 为 phone
+func bareColumn(name string) string {
+	name = strings.TrimSpace(name)
+	return strings.Trim(name[strings.LastIndex(name, ".")+1:], "`\"")
+}
+
+// Select中引用了columns的表达式,只选择列本身的不算
+// Select带参数时gorm把SQL放在SELECT子句中,不在Statement.Selects中
+func (r *Repository[T]) selectExprColumn(columns []string) (string, bool) {
+	exprs := append([]string{}, r.DB.Statement.Selects...)
+	if c, ok := r.DB.Statement.Clauses["SELECT"]; ok {
+		if expr, ok := c.Expression.(clause.Expr); ok {
+			exprs = append(exprs, expr.SQL)
+		}
+	}
+	for _, expr := range exprs {
+		for _, part := range strings.Split(expr, ",") {
+			if containsString(columns, bareColumn(part)) {
+				continue
+			}
+			for _, column := range columns {
+				if regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(column) + `\b`).MatchString(part) {
+					return column, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// 查询后对脱敏的列替换值,非字符串的列置为0值
+// access需要在Reset之前取得,Reset后context已经恢复
+func (r *Repository[T]) maskRows(access map[string]columnAccess, rows ...*T) {
+	if len(access) == 0 || len(rows) == 0 {
+		return
+	}
+	s, err := r.schema()
+	if err != nil {
+		return
+	}
+	for column, policy := range access {
+		if policy.level != FieldMasked && policy.level != FieldHidden {
+			continue
+		}
+		field := s.LookUpField(column)
+		if field == nil {
+			continue
+		}
+		for _, row := range rows {
+			maskField(policy, field.ReflectValueOf(r.DB.Statement.Context, reflect.ValueOf(row).Elem()))
+		}
+	}
+}
+
+func maskField(policy columnAccess, rv reflect.Value) {
+	switch {
+	case policy.level == FieldHidden:
+		rv.Set(reflect.Zero(rv.Type()))
+	case rv.Kind() == reflect.String:
+		rv.SetString(policy.mask(rv.String()))
+	case rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.String:
+		masked := policy.mask(rv.Elem().String())
+		rv.Set(reflect.ValueOf(&masked).Convert(rv.Type()))
+	default:
+		rv.Set(reflect.Zero(rv.Type()))
+	}
+}
+
+func maskValue(policy columnAccess, value any) any {
+	if policy.level == FieldHidden {
+		return nil
+	}
+	switch v := value.(type) {
+	case string:
+		return policy.mask(v)
+	case []byte:
+		return policy.mask(string(v))
+	case *string:
+		if v != nil {
+			masked := policy.mask(*v)
+			return &masked
+		}
+	}
+	return nil
+}
+
+// Scan到结构体或map后对脱敏的列替换值,结构体按字段的列名、map按键匹配
+func (r *Repository[T]) maskScanned(access map[string]columnAccess, dest any) {
+	if len(access) == 0 {
+		return
+	}
+	rv := reflect.Indirect(reflect.ValueOf(dest))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		r.maskScannedValue(access, rv)
+		return
+	}
+	for i := 0; i < rv.Len(); i++ {
+		r.maskScannedValue(access, rv.Index(i))
+	}
+}
+
+func (r *Repository[T]) maskScannedValue(access map[string]columnAccess, rv reflect.Value) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for column, policy := range access {
+			key := reflect.ValueOf(column).Convert(rv.Type().Key())
+			value := rv.MapIndex(key)
+			if !value.IsValid() {
+				continue
+			}
+			if masked := maskValue(policy, value.Interface()); masked != nil {
+				rv.SetMapIndex(key, reflect.ValueOf(masked))
+			} else {
+				rv.SetMapIndex(key, reflect.Zero(rv.Type().Elem()))
+			}
+		}
+	case reflect.Struct:
+		if !rv.CanAddr() {
+			return
+		}
+		s, err := schema.Parse(rv.Addr().Interface(), schemaCache, r.DB.NamingStrategy)
+		if err != nil {
+			return
+		}
+		for column, policy := range access {
+			if field := s.LookUpField(column); field != nil && len(field.DBName) > 0 {
+				maskField(policy, field.ReflectValueOf(r.DB.Statement.Context, rv))
+			}
+		}
+	}
+}
+
+// 按列读取的值,隐藏的列返回错误,脱敏的列替换值
+func (r *Repository[T]) maskValues(access map[string]columnAccess, column string, values []any) ([]any, error) {
+	if s, err := r.schema(); err == nil {
+		if field := s.LookUpField(column); field != nil && len(field.DBName) > 0 {
+			column = field.DBName
+		}
+	}
+	policy, ok := access[column]
+	if !ok {
+		return values, nil
+	}
+	switch policy.level {
+	case FieldHidden:
+		return nil, fmt.Errorf("gorme: column %s is hidden", column)
+	case FieldMasked:
+		for i, value := range values {
+			values[i] = maskValue(policy, value)
+		}
+	}
+	return values, nil
+}
+
+// 检查按map、Setter更新的列是否可写
+func (r *Repository[T]) checkWritable(values any) error {
+	if setter, ok := values.(Setter); ok {
+		values = setter.Data
+	}
+	data, ok := values.(map[string]any)
+	if !ok {
+		return nil
+	}
+	columns := make([]string, 0, len(data))
+	for column := range data {
+		columns = append(columns, column)
+	}
+	return r.checkColumnsWritable(columns...)
+}
+
+func (r *Repository[T]) checkColumnsWritable(columns ...string) error {
+	access := r.fieldAccess()
+	if len(access) == 0 {
+		return nil
+	}
+	s, _ := r.schema()
+	var protected []string
+	for _, column := range columns {
+		name := column[strings.LastIndex(column, ".")+1:]
+		if s != nil {
+			if field := s.LookUpField(name); field != nil && len(field.DBName) > 0 {
+				name = field.DBName
+			}
+		}
+		if _, ok := access[name]; ok {
+			protected = append(protected, column)
+		}
+	}
+	if len(protected) > 0 {
+		return &FieldAccessError{Columns: protected}
+	}
+	return nil
+}
+
+// 插入时检查不可写的列,按map插入时返回*FieldAccessError,按模型插入时忽略这些列
+func (r *Repository[T]) guardInsert(values any) *WriteResult {
+	if err := r.checkWritable(values); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	if len(r.rowsOf(values)) > 0 {
+		r.omitProtected()
+	}
+	return nil
+}
+
+// 当前context下不可写的列
+func (r *Repository[T]) protectedColumns() []string {
+	var protected []string
+	for column := range r.fieldAccess() {
+		protected = append(protected, column)
+	}
+	sort.Strings(protected)
+	return protected
+}
+
+// 按模型写入时忽略不可写的列
+func (r *Repository[T]) omitProtected() {
+	protected := r.protectedColumns()
+	if len(protected) > 0 {
+		r.DB = r.DB.Omit(append(append([]string{}, r.DB.Statement.Omits...), protected...)...)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查更新的列是否可写、枚举值是否有效、是否改了租户,不通过时重置查询并返回错误的结果;按模型更新时忽略不可写的列
+func (r *Repository[T]) guardColumns(values any) *WriteResult {
+	if err := r.checkWritable(values); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	if err := validateEnumMap(values); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	if err := r.checkTenantUpdate(values); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	if len(r.rowsOf(values)) > 0 {
+		r.omitProtected()
+		r.omitTenant()
+	}
+	return nil
+}
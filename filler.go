@@ -0,0 +1,206 @@
+package gorme
+
+import (
+	"context"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 按填充键返回要写入的值,第二个返回值为false时不填充
+type FieldFiller func(ctx context.Context, key string) (any, bool)
+
+type tenantKey struct{}
+type requestIdKey struct{}
+
+// 把租户放入context
+func ContextWithTenant(ctx context.Context, tenant any) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (any, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// 把请求id放入context
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// 从context读取actor、tenant、request_id
+func ContextFiller(ctx context.Context, key string) (any, bool) {
+	switch key {
+	case "actor":
+		actor := ActorFromContext(ctx)
+		return actor, actor != ""
+	case "tenant":
+		return TenantFromContext(ctx)
+	case "request_id":
+		requestId := RequestIdFromContext(ctx)
+		return requestId, requestId != ""
+	}
+	return nil, false
+}
+
+// 设置字段填充器,模型中用标签声明填充的字段
+// `gorme:"fill:actor"`新增和更新时填充,`gorme:"fill:actor,create"`只在新增时填充,`gorme:"fill:actor,update"`只在更新时填充
+// 新增时只填充0值的字段,更新时覆盖
+func (r *Repository[T]) SetFieldFiller(filler FieldFiller) *Repository[T] {
+	r.filler = filler
+	return r
+}
+
+type fillField struct {
+	field    *schema.Field
+	key      string
+	onCreate bool
+	onUpdate bool
+}
+
+// 模型的填充字段缓存
+var fillFieldsCache = &sync.Map{}
+
+// 解析模型中声明了fill标签的字段
+func (r *Repository[T]) fillFields() []fillField {
+	var t T
+	typ := reflect.TypeOf(t)
+	if v, ok := fillFieldsCache.Load(typ); ok {
+		return v.([]fillField)
+	}
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+
+	var fields []fillField
+	for _, field := range s.Fields {
+		tag, ok := field.Tag.Lookup("gorme")
+		if !ok || len(field.DBName) == 0 {
+			continue
+		}
+		for _, setting := range strings.Split(tag, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(setting), ":")
+			if !found || key != "fill" {
+				continue
+			}
+			parts := strings.Split(value, ",")
+			f := fillField{field: field, key: parts[0], onCreate: len(parts) == 1, onUpdate: len(parts) == 1}
+			for _, part := range parts[1:] {
+				switch part {
+				case "create":
+					f.onCreate = true
+				case "update":
+					f.onUpdate = true
+				}
+			}
+			fields = append(fields, f)
+		}
+	}
+	fillFieldsCache.Store(typ, fields)
+	return fields
+}
+
+type fillMode int
+
+const (
+	fillCreate fillMode = iota //按新增填充
+	fillUpdate                 //按更新填充
+	fillSave                   //没有主键时按新增填充,否则按更新填充
+)
+
+// 填充写入的数据
+func (r *Repository[T]) fillRows(rows []*T, mode fillMode) {
+	if r.filler == nil || len(rows) == 0 {
+		return
+	}
+	fields := r.fillFields()
+	if len(fields) == 0 {
+		return
+	}
+
+	ctx := r.DB.Statement.Context
+	for _, row := range rows {
+		rv := reflect.ValueOf(row).Elem()
+		creating := mode == fillCreate || mode == fillSave && !r.hasPrimaryKey(row)
+		for _, f := range fields {
+			if creating && !f.onCreate || !creating && !f.onUpdate {
+				continue
+			}
+			if _, isZero := f.field.ValueOf(ctx, rv); creating && !isZero {
+				continue
+			}
+			if value, ok := r.filler(ctx, f.key); ok {
+				_ = f.field.Set(ctx, rv, value)
+			}
+		}
+	}
+}
+
+// 按更新填充map,返回新的map,不修改传入的map
+func (r *Repository[T]) fillMap(values map[string]any) map[string]any {
+	if r.filler == nil {
+		return values
+	}
+	fields := r.fillFields()
+	if len(fields) == 0 {
+		return values
+	}
+
+	ctx := r.DB.Statement.Context
+	filled := make(map[string]any, len(values)+len(fields))
+	for key, value := range values {
+		filled[key] = value
+	}
+	for _, f := range fields {
+		if !f.onUpdate {
+			continue
+		}
+		if value, ok := r.filler(ctx, f.key); ok {
+			filled[f.field.DBName] = value
+		}
+	}
+	return filled
+}
+
+// 按更新填充Updates的数据,模型有Select时把填充的列加入Select
+func (r *Repository[T]) fillUpdates(values any) any {
+	if r.filler == nil {
+		return values
+	}
+	switch v := values.(type) {
+	case map[string]any:
+		return r.fillMap(v)
+	case Setter:
+		return r.fillMap(v.Data)
+	case T:
+		r.fillRows([]*T{&v}, fillUpdate)
+		values = v
+	case *T:
+		r.fillRows([]*T{v}, fillUpdate)
+	default:
+		return values
+	}
+	if len(r.DB.Statement.Selects) > 0 {
+		selects := append([]string{}, r.DB.Statement.Selects...)
+		for _, f := range r.fillFields() {
+			if f.onUpdate {
+				selects = append(selects, f.field.DBName)
+			}
+		}
+		r.DB = r.DB.Select(selects)
+	}
+	return values
+}
@@ -0,0 +1,35 @@
+package gorme
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+// 位标记列,每一位表示一个标记,如 const (FlagVip gorme.Flags = 1 << iota; FlagBlocked)
+type Flags uint64
+
+// 是否设置了flags中的所有位
+func (f Flags) Has(flags Flags) bool {
+	return f&flags == flags
+}
+
+func (f Flags) Set(flags Flags) Flags {
+	return f | flags
+}
+
+func (f Flags) Clear(flags Flags) Flags {
+	return f &^ flags
+}
+
+// 位标记列设置了flags中的所有位
+// SELECT * FROM `tb_order` WHERE `flags` & 3 = 3
+func (r *Repository[T]) HasFlag(column string, flags Flags) *Repository[T] {
+	r.DB = r.DB.Where("? & ? = ?", clause.Column{Name: column}, uint64(flags), uint64(flags))
+	return r
+}
+
+// 位标记列没有设置flags中的任何一位
+// SELECT * FROM `tb_order` WHERE `flags` & 4 = 0
+func (r *Repository[T]) LacksFlag(column string, flags Flags) *Repository[T] {
+	r.DB = r.DB.Where("? & ? = 0", clause.Column{Name: column}, uint64(flags))
+	return r
+}
@@ -3,12 +3,12 @@ module github.com/micrease/gorme
 go 1.18
 
 require (
+	github.com/go-sql-driver/mysql v1.6.0
 	gorm.io/driver/mysql v1.4.3
 	gorm.io/gorm v1.25.4
 )
 
 require (
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 )
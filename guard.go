@@ -0,0 +1,122 @@
+package gorme
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"strings"
+)
+
+// 没有where条件的全表更新/删除
+type FullTableError struct {
+	Operation string
+	Table     string
+}
+
+func (e *FullTableError) Error() string {
+	return fmt.Sprintf("gorme: refusing to %s table %s without where conditions, use AllowFullTable() if intended", e.Operation, e.Table)
+}
+
+// 可以用errors.Is(err, gorm.ErrMissingWhereClause)判断
+func (e *FullTableError) Is(target error) bool {
+	return target == gorm.ErrMissingWhereClause
+}
+
+// 影响行数超过了SetMaxAffectedRows设置的上限,事务已回滚
+type AffectedRowsError struct {
+	Operation    string
+	Limit        int64
+	RowsAffected int64
+}
+
+func (e *AffectedRowsError) Error() string {
+	return fmt.Sprintf("gorme: %s affected %d rows, exceeds the limit %d, rolled back", e.Operation, e.RowsAffected, e.Limit)
+}
+
+// 设置更新/删除的最大影响行数,超过时回滚,小于等于0为不限制
+func (r *Repository[T]) SetMaxAffectedRows(limit int64) *Repository[T] {
+	r.maxAffectedRows = limit
+	return r
+}
+
+// 允许当前的更新/删除操作全表执行
+func (r *Repository[T]) AllowFullTable() *Repository[T] {
+	r.state.allowFullTable = true
+	return r
+}
+
+// 把Delete(conds...)这类内联条件转成where
+func (r *Repository[T]) whereConds(conds []interface{}) {
+	if len(conds) > 0 {
+		r.DB = r.DB.Where(conds[0], conds[1:]...)
+	}
+}
+
+// 执行更新/删除,拒绝没有where条件的全表操作,超过最大影响行数时回滚
+// value为更新的数据,设置了主键的模型也视为有条件
+func (r *Repository[T]) guardedWrite(operation string, value any, exec func(db *gorm.DB) *gorm.DB) *WriteResult {
+	if !r.state.allowFullTable && !r.hasWhere(value) {
+		err := &FullTableError{Operation: operation, Table: r.DB.Statement.Table}
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+
+	r.prepare()
+	return r.execWrite(operation, value, r.maxAffectedRows, r.audited(operation, value, exec))
+}
+
+// 当前查询是否有用户指定的where条件,须在prepare之前调用
+func (r *Repository[T]) hasWhere(value any) bool {
+	if r.hasPrimaryKey(value) || r.hasPrimaryKey(r.DB.Statement.Model) {
+		return true
+	}
+	c, ok := r.DB.Statement.Clauses["WHERE"]
+	if !ok {
+		return false
+	}
+	where, ok := c.Expression.(clause.Where)
+	return ok && !alwaysTrue(where.Exprs)
+}
+
+var alwaysTrueSQL = regexp.MustCompile(`^(true|1|(\d+)=(\d+)|'([^']*)'='([^']*)')$`)
+
+// 条件为空或恒为真,如1=1
+func alwaysTrue(exprs []clause.Expression) bool {
+	//gorm中OrConditions与前面的条件用OR连接,按OR拆分后任一组恒为真则整体恒为真
+	group := true
+	for i, expr := range exprs {
+		if or, ok := expr.(clause.OrConditions); ok && i > 0 {
+			if group {
+				return true
+			}
+			group = alwaysTrue(or.Exprs)
+			continue
+		}
+		if !alwaysTrueExpr(expr) {
+			group = false
+		}
+	}
+	return group
+}
+
+func alwaysTrueExpr(expr clause.Expression) bool {
+	switch v := expr.(type) {
+	case clause.AndConditions:
+		return alwaysTrue(v.Exprs)
+	case clause.OrConditions:
+		return alwaysTrue(v.Exprs)
+	case clause.Expr:
+		if len(v.Vars) > 0 {
+			return false
+		}
+		sql := strings.ToLower(strings.Join(strings.Fields(v.SQL), ""))
+		sql = strings.TrimSuffix(strings.TrimPrefix(sql, "("), ")")
+		if sql == "" {
+			return true
+		}
+		m := alwaysTrueSQL.FindStringSubmatch(sql)
+		return m != nil && m[2] == m[3] && m[4] == m[5]
+	}
+	return false
+}
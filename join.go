@@ -0,0 +1,144 @@
+package gorme
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 把当前查询的结果插入到target的表中,columns为目标表的列
+// 未调用Select时按columns查询,写入的是其它表,开启审计时也不记录审计日志
+// INSERT INTO `tb_order_archive` (`id`,`user_id`,`amount`) SELECT `id`,`user_id`,`amount` FROM `tb_order` WHERE created_at<'2022-01-01' AND `tb_order`.`deleted_at` IS NULL
+func (r *Repository[T]) InsertInto(target Model, columns ...string) *WriteResult {
+	if len(columns) > 0 && len(r.DB.Statement.Selects) == 0 {
+		r.DB = r.DB.Select(columns)
+	}
+	r.prepare()
+	query := r.DB
+
+	sql := "INSERT INTO ?"
+	vars := []any{clause.Table{Name: target.TableName()}}
+	if len(columns) > 0 {
+		targetColumns := make([]clause.Column, 0, len(columns))
+		for _, column := range columns {
+			targetColumns = append(targetColumns, clause.Column{Name: column})
+		}
+		sql += " ?"
+		vars = append(vars, targetColumns)
+	}
+	sql += " ?"
+	vars = append(vars, query)
+
+	return r.execSQL("insert", sql, vars)
+}
+
+// 有Joins时的更新,MySQL下未指定表名的列加上主表名,避免与联表的列冲突
+// PostgreSQL/SQLite的SET不允许带表名
+// touch为true时同时更新updated_at等自动更新时间的列
+func (r *Repository[T]) joinedUpdate(values map[string]any, touch bool) *WriteResult {
+	var t T
+	table := ""
+	if r.DB.Dialector.Name() == "mysql" {
+		table = r.DB.Statement.Table + "."
+	}
+	assignments := make(map[string]any, len(values))
+	for column, value := range values {
+		if !strings.Contains(column, ".") {
+			column = table + column
+		}
+		assignments[column] = value
+	}
+
+	if s, err := r.schema(); err == nil && touch {
+		now := time.Now()
+		for _, field := range s.Fields {
+			if field.AutoUpdateTime == 0 || values[field.DBName] != nil || values[field.Name] != nil {
+				continue
+			}
+			var value any = now
+			switch field.AutoUpdateTime {
+			case schema.UnixSecond:
+				value = now.Unix()
+			case schema.UnixMillisecond:
+				value = now.UnixNano() / 1e6
+			case schema.UnixNanosecond:
+				value = now.UnixNano()
+			}
+			assignments[table+field.DBName] = value
+		}
+	}
+
+	return r.guardedWrite("update", nil, func(db *gorm.DB) *gorm.DB {
+		return r.joinedUpdateDB(db).Model(&t).UpdateColumns(assignments)
+	})
+}
+
+// UPDATE带上联表
+// MySQL: UPDATE `tb_order` JOIN tb_user u ON ... SET ...
+// PostgreSQL/SQLite: UPDATE "tb_order" SET ... FROM tb_user u WHERE ON条件 AND ...
+func (r *Repository[T]) joinedUpdateDB(db *gorm.DB) *gorm.DB {
+	if r.DB.Dialector.Name() == "mysql" {
+		return db.Clauses(joinedTable{joins: r.state.joins})
+	}
+
+	from := clause.From{}
+	var conds []clause.Expression
+	for _, join := range r.state.joins {
+		m := joinPattern.FindStringSubmatch(join.SQL)
+		if m == nil {
+			db = db.Session(&gorm.Session{})
+			db.AddError(fmt.Errorf("gorme: unsupported join %q for update", join.SQL))
+			return db
+		}
+		from.Tables = append(from.Tables, clause.Table{Name: m[1], Raw: true})
+		conds = append(conds, clause.Expr{SQL: m[2], Vars: join.Vars})
+	}
+	return db.Clauses(from, clause.Where{Exprs: conds})
+}
+
+// DELETE带上联表
+// MySQL: DELETE `tb_order` FROM `tb_order` JOIN tb_user u ON ... WHERE ...
+// PostgreSQL/SQLite: DELETE FROM "tb_order" WHERE "id" IN (SELECT "tb_order"."id" FROM "tb_order" JOIN ... WHERE ...)
+func (r *Repository[T]) joinedDeleteDB(db *gorm.DB) *gorm.DB {
+	if r.DB.Dialector.Name() == "mysql" {
+		return db.Clauses(joinedTable{joins: r.state.joins, delete: true})
+	}
+
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		db = db.Session(&gorm.Session{})
+		db.AddError(err)
+		return db
+	}
+	var t T
+	primaryKey := clause.Column{Name: s.PrioritizedPrimaryField.DBName}
+	subQuery := db.Session(&gorm.Session{}).Select("?", clause.Column{Table: clause.CurrentTable, Name: primaryKey.Name})
+	return db.Session(&gorm.Session{NewDB: true}).Model(&t).Table(r.DB.Statement.Table).Where("? IN (?)", primaryKey, subQuery)
+}
+
+var joinPattern = regexp.MustCompile(`(?is)^\s*(?:(?:inner|left|right|full|cross)\s+(?:outer\s+)?)?join\s+(.+?)\s+on\s+(.+)$`)
+
+// MySQL多表UPDATE/DELETE,把联表拼到表名后面
+type joinedTable struct {
+	joins  []clause.Expr
+	delete bool
+}
+
+func (j joinedTable) ModifyStatement(stmt *gorm.Statement) {
+	sql := "?"
+	vars := []any{clause.Table{Name: stmt.Table}}
+	for _, join := range j.joins {
+		sql += " " + join.SQL
+		vars = append(vars, join.Vars...)
+	}
+	stmt.TableExpr = &clause.Expr{SQL: sql, Vars: vars}
+	if j.delete {
+		stmt.Clauses["DELETE"] = clause.Clause{Expression: clause.Delete{Modifier: stmt.Quote(stmt.Table)}}
+	}
+}
+
+func (joinedTable) Build(clause.Builder) {}
@@ -0,0 +1,207 @@
+package gorme
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSON列,写入时序列化为JSON,读取时反序列化到Data
+type JSON[V any] struct {
+	Data V
+}
+
+func NewJSON[V any](data V) JSON[V] {
+	return JSON[V]{Data: data}
+}
+
+func (j JSON[V]) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.Data)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (j *JSON[V]) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		var zero V
+		j.Data = zero
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("gorme: cannot scan %T into JSON", src)
+	}
+	return json.Unmarshal(b, &j.Data)
+}
+
+func (j JSON[V]) GormDataType() string {
+	return "json"
+}
+
+func (j JSON[V]) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "JSONB"
+	}
+	return "JSON"
+}
+
+func (j JSON[V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Data)
+}
+
+func (j *JSON[V]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &j.Data)
+}
+
+// JSON路径中的一段,如a、[0]
+var jsonPathPattern = regexp.MustCompile(`[^.\[\]]+|\[\d+\]`)
+
+// 路径如a.b[0].c,可以带$前缀;返回MySQL、SQLite的$.a.b[0].c和PostgreSQL的{a,b,0,c}
+func jsonPath(path string) (string, string) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if len(path) == 0 {
+		return "$", "{}"
+	}
+	var keys []string
+	for _, key := range jsonPathPattern.FindAllString(path, -1) {
+		keys = append(keys, strings.Trim(key, "[]"))
+	}
+	if strings.HasPrefix(path, "[") {
+		return "$" + path, "{" + strings.Join(keys, ",") + "}"
+	}
+	return "$." + path, "{" + strings.Join(keys, ",") + "}"
+}
+
+// 取JSON列中path的值,MySQL、PostgreSQL取出的是文本,value为数字、布尔时按对应类型比较
+// 返回比较的两边,MySQL中取出的布尔值为文本true,改为按JSON值比较
+func (r *Repository[T]) jsonExtract(column, path string, value any) (clause.Expr, any) {
+	col := clause.Column{Name: column}
+	mysqlPath, pgPath := jsonPath(path)
+	switch r.DB.Dialector.Name() {
+	case "postgres":
+		cast := ""
+		switch value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			cast = "::numeric"
+		case bool:
+			cast = "::boolean"
+		}
+		return clause.Expr{SQL: "(? #>> ?)" + cast, Vars: []any{col, pgPath}}, value
+	case "sqlite":
+		return clause.Expr{SQL: "json_extract(?, ?)", Vars: []any{col, mysqlPath}}, value
+	}
+	if b, ok := value.(bool); ok {
+		return clause.Expr{SQL: "JSON_EXTRACT(?, ?)", Vars: []any{col, mysqlPath}}, clause.Expr{SQL: "CAST(? AS JSON)", Vars: []any{strconv.FormatBool(b)}}
+	}
+	return clause.Expr{SQL: "JSON_UNQUOTE(JSON_EXTRACT(?, ?))", Vars: []any{col, mysqlPath}}, value
+}
+
+var jsonOperators = map[string]bool{"=": true, "!=": true, "<>": true, ">": true, ">=": true, "<": true, "<=": true, "LIKE": true, "NOT LIKE": true}
+
+// 按JSON列中path的值查询
+// SELECT * FROM `tb_order` WHERE JSON_UNQUOTE(JSON_EXTRACT(`extra`, '$.address.city')) = 'beijing'
+func (r *Repository[T]) WhereJSON(column, path, op string, value any) *Repository[T] {
+	op = strings.ToUpper(strings.TrimSpace(op))
+	if !jsonOperators[op] {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: unsupported operator %s for WhereJSON", op))
+		return r
+	}
+	expr, value := r.jsonExtract(column, path, value)
+	r.DB = r.DB.Where(clause.Expr{SQL: "? " + op + " ?", Vars: []any{expr, value}})
+	return r
+}
+
+// JSON列中path的值包含value,value为数组时要求包含其中所有元素,path为空时为整列
+// SELECT * FROM `tb_order` WHERE JSON_CONTAINS(`extra`, '["vip"]', '$.tags')
+func (r *Repository[T]) JSONContains(column string, value any, path ...string) *Repository[T] {
+	b, err := json.Marshal(value)
+	if err != nil {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(err)
+		return r
+	}
+	col := clause.Column{Name: column}
+	mysqlPath, pgPath := jsonPath(strings.Join(path, "."))
+	switch r.DB.Dialector.Name() {
+	case "postgres":
+		r.DB = r.DB.Where("(? #> ?) @> ?::jsonb", col, pgPath, string(b))
+	case "sqlite":
+		//SQLite没有JSON包含,按json_each逐个比较
+		values := []any{value}
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			values = make([]any, rv.Len())
+			for i := range values {
+				values[i] = rv.Index(i).Interface()
+			}
+		}
+		for _, v := range values {
+			r.DB = r.DB.Where("EXISTS (SELECT 1 FROM json_each(?, ?) WHERE json_each.value = ?)", col, mysqlPath, v)
+		}
+	default:
+		r.DB = r.DB.Where("JSON_CONTAINS(?, ?, ?)", col, string(b), mysqlPath)
+	}
+	return r
+}
+
+// 按JSON列中path的数组长度查询,path为空时为整列
+// SELECT * FROM `tb_order` WHERE JSON_LENGTH(`extra`, '$.tags') > 2
+func (r *Repository[T]) JSONLength(column, path, op string, length int) *Repository[T] {
+	op = strings.TrimSpace(op)
+	if !jsonOperators[op] || strings.Contains(op, "LIKE") {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: unsupported operator %s for JSONLength", op))
+		return r
+	}
+	col := clause.Column{Name: column}
+	mysqlPath, pgPath := jsonPath(path)
+	switch r.DB.Dialector.Name() {
+	case "postgres":
+		r.DB = r.DB.Where("jsonb_array_length(? #> ?) "+op+" ?", col, pgPath, length)
+	case "sqlite":
+		r.DB = r.DB.Where("json_array_length(?, ?) "+op+" ?", col, mysqlPath, length)
+	default:
+		r.DB = r.DB.Where("JSON_LENGTH(?, ?) "+op+" ?", col, mysqlPath, length)
+	}
+	return r
+}
+
+// 按JSON列中path的值排序,与Order()共用排序列表,按调用顺序排列
+// path转为字符串常量写入排序列,其中的引号已转义
+// SELECT * FROM `tb_order` ORDER BY JSON_EXTRACT(`extra`, '$.priority') DESC,id
+func (r *Repository[T]) OrderByJSON(column, path string, desc bool) *Repository[T] {
+	col := r.DB.Statement.Quote(clause.Column{Name: column})
+	mysqlPath, pgPath := jsonPath(path)
+	var sql string
+	switch r.DB.Dialector.Name() {
+	case "postgres":
+		sql = col + " #> " + quoteLiteral(pgPath, false)
+	case "sqlite":
+		sql = "json_extract(" + col + ", " + quoteLiteral(mysqlPath, false) + ")"
+	default:
+		sql = "JSON_EXTRACT(" + col + ", " + quoteLiteral(mysqlPath, true) + ")"
+	}
+	r.DB = r.DB.Order(clause.OrderByColumn{Column: clause.Column{Name: sql, Raw: true}, Desc: desc})
+	return r
+}
+
+// 字符串常量,单引号写两次,MySQL中反斜杠也需要转义
+func quoteLiteral(value string, backslash bool) string {
+	if backslash {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,307 @@
+package gorme
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSON补丁中无法应用的字段
+type PatchError struct {
+	Unknown  []string         //模型中不存在的字段
+	ReadOnly []string         //主键、自动时间、租户列、不可更新或不在白名单中的字段
+	Invalid  map[string]error //类型不匹配的字段
+}
+
+func (e *PatchError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unknown fields "+strings.Join(e.Unknown, ","))
+	}
+	if len(e.ReadOnly) > 0 {
+		parts = append(parts, "read-only fields "+strings.Join(e.ReadOnly, ","))
+	}
+	if len(e.Invalid) > 0 {
+		names := make([]string, 0, len(e.Invalid))
+		for name := range e.Invalid {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("invalid field %s: %v", name, e.Invalid[name]))
+		}
+	}
+	return "gorme: invalid patch: " + strings.Join(parts, "; ")
+}
+
+// 按RFC 7396 JSON Merge Patch更新主键为id的记录
+// 补丁中没有的字段不更新,null更新为NULL,0值和空字符串照常更新;serializer字段和JSON[T]等JSON列按当前值合并
+// 合并时在事务中按当前查询的条件(租户、数据权限、软删除等)加锁读取当前值
+// 字段名可以是json标签名、结构体字段名或列名,columns不为空时只允许更新其中的列
+// 有未知、只读或类型不匹配的字段时返回*PatchError,不执行更新
+// UPDATE `tb_order` SET `amount`=0,`goods_name`='pen',`updated_at`='2023-01-03 10:21:05.12' WHERE `tb_order`.`id` = 1 AND `tb_order`.`deleted_at` IS NULL
+func (r *Repository[T]) PatchFromJSON(id any, patch []byte, columns ...string) *WriteResult {
+	s, err := r.schemaWithPrimaryKey()
+	var fields map[string]json.RawMessage
+	if err == nil {
+		fields, err = decodePatch(patch)
+	}
+	if err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	r.DB = r.DB.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}, Value: id})
+
+	if !patchMerges(s, fields) {
+		values, err := r.parsePatch(s, fields, columns, nil)
+		if err != nil || len(values) == 0 {
+			r.Reset()
+			return &WriteResult{Error: err}
+		}
+		return r.Updates(values)
+	}
+
+	//读取当前值和更新在同一事务中,当前行加锁,并发的补丁不会互相覆盖
+	base, state := r.DB, r.state
+	var result *WriteResult
+	err = r.Transaction(func(tx *gorm.DB) error {
+		r.DB = tx
+		values, err := r.parsePatch(s, fields, columns, r.lockCurrent)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+		result = r.Updates(values)
+		return result.Error
+	})
+	r.DB, r.state = base, state
+	r.Reset()
+	if result == nil || result.Error == nil && err != nil {
+		result = &WriteResult{Error: err}
+	}
+	return result
+}
+
+// 按当前查询的条件加锁读取要合并的行
+func (r *Repository[T]) lockCurrent() (reflect.Value, error) {
+	var t T
+	r.prepare()
+	db := r.DB.Session(&gorm.Session{})
+	if r.DB.Dialector.Name() != "sqlite" {
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	if err := db.Take(&t).Error; err != nil {
+		return reflect.Value{}, r.translateError(err)
+	}
+	return reflect.ValueOf(&t).Elem(), nil
+}
+
+func decodePatch(patch []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("gorme: patch must be a JSON object: %w", err)
+	}
+	return fields, nil
+}
+
+// 补丁中是否有需要按当前值合并的JSON列
+func patchMerges(s *schema.Schema, fields map[string]json.RawMessage) bool {
+	for name, raw := range fields {
+		if field := lookUpPatchField(s, name); field != nil && mergeable(field) && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			return true
+		}
+	}
+	return false
+}
+
+// 把JSON补丁转成按列名的更新数据,load读取合并用的当前值
+func (r *Repository[T]) parsePatch(s *schema.Schema, fields map[string]json.RawMessage, columns []string, load func() (reflect.Value, error)) (map[string]any, error) {
+	var err error
+	allowed := map[string]bool{}
+	for _, column := range columns {
+		allowed[column] = true
+	}
+
+	patchErr := &PatchError{Invalid: map[string]error{}}
+	values := map[string]any{}
+	var current reflect.Value
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		raw := fields[name]
+		field := lookUpPatchField(s, name)
+		if field == nil {
+			patchErr.Unknown = append(patchErr.Unknown, name)
+			continue
+		}
+		if !patchable(field) || field.DBName == r.tenantColumn() || (len(allowed) > 0 && !allowed[field.DBName] && !allowed[field.Name]) {
+			patchErr.ReadOnly = append(patchErr.ReadOnly, name)
+			continue
+		}
+
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			values[field.DBName] = nil
+			continue
+		}
+
+		//serializer字段、JSON[T]等JSON列,对象按当前值合并
+		merged := false
+		if load != nil && mergeable(field) && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			if !current.IsValid() {
+				if current, err = load(); err != nil {
+					return nil, err
+				}
+			}
+			if origin, ok := currentJSON(field, field.ReflectValueOf(context.Background(), current)); ok {
+				if raw, err = mergePatch(origin, raw); err != nil {
+					patchErr.Invalid[name] = err
+					continue
+				}
+				merged = true
+			}
+		}
+
+		value := reflect.New(field.FieldType)
+		if scanner, ok := value.Interface().(sql.Scanner); ok && merged && field.Serializer == nil {
+			//实现了driver.Valuer的列按数据库中的值读回
+			err = scanner.Scan([]byte(raw))
+		} else {
+			err = json.Unmarshal(raw, value.Interface())
+		}
+		if err != nil {
+			patchErr.Invalid[name] = err
+			continue
+		}
+		if field.Serializer != nil {
+			//通过serializer转成数据库中的值
+			var t T
+			rv := reflect.ValueOf(&t).Elem()
+			if err = field.Set(context.Background(), rv, value.Elem().Interface()); err != nil {
+				patchErr.Invalid[name] = err
+				continue
+			}
+			v, _ := field.ValueOf(context.Background(), rv)
+			values[field.DBName] = v
+			continue
+		}
+		values[field.DBName] = value.Elem().Interface()
+	}
+
+	if len(patchErr.Unknown) > 0 || len(patchErr.ReadOnly) > 0 || len(patchErr.Invalid) > 0 {
+		return nil, patchErr
+	}
+	return values, nil
+}
+
+// 按json标签名、结构体字段名、列名查找字段
+func lookUpPatchField(s *schema.Schema, name string) *schema.Field {
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 {
+			continue
+		}
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == name && tag != "-" {
+			return field
+		}
+	}
+	if field := s.LookUpField(name); field != nil && len(field.DBName) > 0 {
+		return field
+	}
+	return nil
+}
+
+// 字段是否允许通过补丁更新
+func patchable(field *schema.Field) bool {
+	if field.PrimaryKey || !field.Updatable || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+		return false
+	}
+	return field.FieldType != reflect.TypeOf(gorm.DeletedAt{})
+}
+
+// 字段是否保存JSON:serializer字段、数据类型为json的字段如JSON[T]、实现了driver.Valuer的字段
+func mergeable(field *schema.Field) bool {
+	if field.Serializer != nil || field.DataType == "json" {
+		return true
+	}
+	return reflect.PtrTo(field.FieldType).Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem())
+}
+
+// 字段当前值的JSON,driver.Valuer在数据库中的值不是JSON对象时返回false
+func currentJSON(field *schema.Field, rv reflect.Value) ([]byte, bool) {
+	if field.Serializer != nil || field.DataType == "json" {
+		b, err := json.Marshal(rv.Interface())
+		return b, err == nil
+	}
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, true
+	}
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() {
+		rv = rv.Addr()
+	}
+	valuer, ok := rv.Interface().(driver.Valuer)
+	if !ok {
+		return nil, false
+	}
+	v, err := valuer.Value()
+	if err != nil {
+		return nil, false
+	}
+	var b []byte
+	switch v := v.(type) {
+	case nil:
+		return nil, true
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return nil, false
+	}
+	if !json.Valid(b) || !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return nil, false
+	}
+	return b, true
+}
+
+// RFC 7396,把patch合并到target上
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var t, p any
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(target, &t); err != nil {
+		t = nil
+	}
+	return json.Marshal(mergeValue(t, p))
+}
+
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+	return targetObject
+}
@@ -0,0 +1,108 @@
+package gorme
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 数据权限策略,按context返回模型的过滤条件,第二个返回值为false时不过滤
+// gorme.RegisterDataPolicy[OrderModel]("region", func(ctx context.Context) (clause.Expression, bool) {...})
+type DataPolicy func(ctx context.Context) (clause.Expression, bool)
+
+// 数据权限过滤的报告
+type PolicyReport struct {
+	Table      string
+	Policies   []string //生效的策略名
+	Conditions []string //策略的条件
+	DryRun     bool     //是否只报告,未加到查询中
+}
+
+type namedPolicy struct {
+	name   string
+	policy DataPolicy
+}
+
+type policyRegistry struct {
+	mu       sync.RWMutex
+	policies []namedPolicy
+}
+
+var dataPolicies = &sync.Map{}
+
+func policiesOf[T Model]() *policyRegistry {
+	var t T
+	v, _ := dataPolicies.LoadOrStore(reflect.TypeOf(t), &policyRegistry{})
+	return v.(*policyRegistry)
+}
+
+// 登记模型的数据权限策略,同名策略会被替换,查询、更新、删除时自动加上策略的条件
+func RegisterDataPolicy[T Model](name string, policy DataPolicy) {
+	registry := policiesOf[T]()
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for i, p := range registry.policies {
+		if p.name == name {
+			registry.policies[i].policy = policy
+			return
+		}
+	}
+	registry.policies = append(registry.policies, namedPolicy{name: name, policy: policy})
+}
+
+// 开启后数据权限策略只报告不过滤,用于上线前核对策略
+func (r *Repository[T]) SetDataPolicyDryRun(enabled bool) *Repository[T] {
+	r.policyDryRun = enabled
+	return r
+}
+
+// 设置数据权限过滤的报告函数,有策略生效时调用;未设置时dry run模式下输出到日志
+func (r *Repository[T]) SetPolicyReporter(reporter func(ctx context.Context, report PolicyReport)) *Repository[T] {
+	r.policyReporter = reporter
+	return r
+}
+
+// 把生效的数据权限策略加到当前查询
+func (r *Repository[T]) applyDataPolicies() {
+	registry := policiesOf[T]()
+	registry.mu.RLock()
+	policies := append([]namedPolicy{}, registry.policies...)
+	registry.mu.RUnlock()
+	if len(policies) == 0 {
+		return
+	}
+
+	ctx := r.DB.Statement.Context
+	report := PolicyReport{Table: r.DB.Statement.Table, DryRun: r.policyDryRun}
+	for _, p := range policies {
+		cond, ok := p.policy(ctx)
+		if !ok || cond == nil {
+			continue
+		}
+		report.Policies = append(report.Policies, p.name)
+		report.Conditions = append(report.Conditions, r.explain(cond))
+		if !r.policyDryRun {
+			r.DB = r.DB.Where(cond)
+		}
+	}
+	if len(report.Policies) == 0 {
+		return
+	}
+
+	if r.policyReporter != nil {
+		r.policyReporter(ctx, report)
+	} else if r.policyDryRun {
+		r.DB.Logger.Info(ctx, "gorme: data policy dry run on %s: %s (%s)", report.Table,
+			strings.Join(report.Policies, ","), strings.Join(report.Conditions, " AND "))
+	}
+}
+
+// 把条件转成SQL文本
+func (r *Repository[T]) explain(expr clause.Expression) string {
+	stmt := &gorm.Statement{DB: r.DB, Table: r.DB.Statement.Table, Schema: r.DB.Statement.Schema, Clauses: map[string]clause.Clause{}}
+	expr.Build(stmt)
+	return r.DB.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)
+}
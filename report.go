@@ -0,0 +1,396 @@
+package gorme
+
+import (
+	"encoding/csv"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 报表的维度,Column为列或SQL表达式,如status、u.user_name
+type Dimension struct {
+	Name   string
+	Column string
+	//需要的联表,如 LEFT JOIN tb_user u ON u.id = tb_order.user_id
+	Join string
+	//不为空时按时间分段,Location为nil时为本地时区
+	Bucket   TimeBucket
+	Location *time.Location
+}
+
+// 报表的指标,SQL为聚合表达式
+type Metric struct {
+	Name string
+	SQL  string
+	Join string
+}
+
+func Count(name string) Metric {
+	return Metric{Name: name, SQL: "COUNT(*)"}
+}
+
+func Sum(name, column string) Metric {
+	return Metric{Name: name, SQL: "SUM(" + column + ")"}
+}
+
+func Avg(name, column string) Metric {
+	return Metric{Name: name, SQL: "AVG(" + column + ")"}
+}
+
+func CountDistinct(name, column string) Metric {
+	return Metric{Name: name, SQL: "COUNT(DISTINCT " + column + ")"}
+}
+
+// 两个指标的比值,分母为0时为NULL
+func Ratio(name string, numerator, denominator Metric) Metric {
+	join := numerator.Join
+	if len(join) == 0 {
+		join = denominator.Join
+	}
+	return Metric{Name: name, SQL: "(" + numerator.SQL + ") * 1.0 / NULLIF(" + denominator.SQL + ", 0)", Join: join}
+}
+
+// 声明模型T可用的维度和指标,同一个报表可以按不同的组合多次查询
+type Report[T Model] struct {
+	dimensions map[string]Dimension
+	metrics    map[string]Metric
+}
+
+func NewReport[T Model]() *Report[T] {
+	return &Report[T]{dimensions: map[string]Dimension{}, metrics: map[string]Metric{}}
+}
+
+func (rp *Report[T]) Dimension(dimensions ...Dimension) *Report[T] {
+	for _, d := range dimensions {
+		if len(d.Column) == 0 {
+			d.Column = d.Name
+		}
+		rp.dimensions[d.Name] = d
+	}
+	return rp
+}
+
+func (rp *Report[T]) Metric(metrics ...Metric) *Report[T] {
+	for _, m := range metrics {
+		rp.metrics[m.Name] = m
+	}
+	return rp
+}
+
+// 指标的过滤条件,生成HAVING
+type MetricFilter struct {
+	Metric string
+	Op     string
+	Value  any
+}
+
+// 一次报表查询,Sort为维度或指标名,前面加-为倒序;PageSize大于0时分页
+type ReportRequest struct {
+	Dimensions []string
+	Metrics    []string
+	Having     []MetricFilter
+	Sort       []string
+	PageNo     int
+	PageSize   int
+}
+
+type ReportRow struct {
+	Dimensions []any      //按ReportTable.Dimensions的顺序,时间分段为time.Time
+	Metrics    []*float64 //值为NULL时为nil,如Ratio的分母为0
+}
+
+type ReportTable struct {
+	Dimensions []string
+	Metrics    []string
+	Rows       []ReportRow
+	//分页时为分组的总数
+	Total int64
+}
+
+// 报表中的别名,避免和列名冲突
+func reportAlias(name string) string {
+	return "r_" + name
+}
+
+// 按request查询报表,query上的条件作为过滤条件
+//
+//	report.Run(repo.NewQuery().Where("status", "paid"), gorme.ReportRequest{Dimensions: []string{"day"}, Metrics: []string{"orders"}})
+func (rp *Report[T]) Run(query *Repository[T], request ReportRequest) (*ReportTable, error) {
+	table := &ReportTable{Dimensions: request.Dimensions, Metrics: request.Metrics}
+	if len(request.Metrics) == 0 {
+		query.Reset()
+		return nil, fmt.Errorf("gorme: report requires at least one metric")
+	}
+
+	var selects []string
+	var vars []any
+	var groups []string
+	joins := map[string]bool{}
+	addJoin := func(join string) {
+		if len(join) > 0 && !joins[join] {
+			joins[join] = true
+			query.Joins(join)
+		}
+	}
+	for _, name := range request.Dimensions {
+		d, ok := rp.dimensions[name]
+		if !ok {
+			query.Reset()
+			return nil, fmt.Errorf("gorme: unknown report dimension %s", name)
+		}
+		addJoin(d.Join)
+	}
+	for _, name := range request.Metrics {
+		m, ok := rp.metrics[name]
+		if !ok {
+			query.Reset()
+			return nil, fmt.Errorf("gorme: unknown report metric %s", name)
+		}
+		addJoin(m.Join)
+	}
+	//联表后再加上租户、数据权限等条件,分段的时间范围按这些条件查询
+	query.prepare()
+
+	for _, name := range request.Dimensions {
+		d := rp.dimensions[name]
+		alias := query.DB.Statement.Quote(reportAlias(name))
+		if len(d.Bucket) > 0 {
+			loc := d.Location
+			if loc == nil {
+				loc = time.Local
+			}
+			group := &timeGroup{column: d.Column, bucket: d.Bucket, loc: loc}
+			from, to, err := query.bucketRange(group, time.Time{}, time.Time{})
+			if err != nil {
+				query.Reset()
+				return nil, query.translateError(err)
+			}
+			selects = append(selects, "? AS "+alias)
+			vars = append(vars, query.bucketExpr(group, from, to))
+		} else {
+			selects = append(selects, d.Column+" AS "+alias)
+		}
+		groups = append(groups, reportAlias(name))
+	}
+	for _, name := range request.Metrics {
+		m := rp.metrics[name]
+		selects = append(selects, m.SQL+" AS "+query.DB.Statement.Quote(reportAlias(name)))
+	}
+
+	query.DB = query.DB.Select(strings.Join(selects, ", "), vars...)
+	for _, group := range groups {
+		query.DB = query.DB.Group(group)
+	}
+	for _, filter := range request.Having {
+		m, ok := rp.metrics[filter.Metric]
+		op := strings.TrimSpace(filter.Op)
+		if !ok || !jsonOperators[op] || strings.Contains(op, "LIKE") {
+			query.Reset()
+			return nil, fmt.Errorf("gorme: invalid report filter %s %s", filter.Metric, filter.Op)
+		}
+		query.DB = query.DB.Having(m.SQL+" "+op+" ?", filter.Value)
+	}
+	//prepare时还没有Select,再检查维度和指标中的隐藏、脱敏列
+	query.applyFieldPolicies()
+
+	if request.PageSize > 0 {
+		err := query.DB.Session(&gorm.Session{NewDB: true}).Table("(?) AS report", query.DB.Session(&gorm.Session{})).Count(&table.Total).Error
+		if err != nil {
+			query.Reset()
+			return nil, query.translateError(err)
+		}
+		pageNo := request.PageNo
+		if pageNo < 1 {
+			pageNo = 1
+		}
+		query.DB = query.DB.Limit(request.PageSize).Offset((pageNo - 1) * request.PageSize)
+	}
+	for _, s := range request.Sort {
+		//只能按本次查询的维度和指标排序
+		name := strings.TrimPrefix(s, "-")
+		if !containsString(request.Dimensions, name) && !containsString(request.Metrics, name) {
+			query.Reset()
+			return nil, fmt.Errorf("gorme: unknown report sort %s", s)
+		}
+		query.DB = query.DB.Order(clause.OrderByColumn{Column: clause.Column{Name: reportAlias(name)}, Desc: strings.HasPrefix(s, "-")})
+	}
+
+	rows, err := query.DB.Rows()
+	query.Reset()
+	if err != nil {
+		return nil, query.translateError(err)
+	}
+	defer rows.Close()
+
+	size := len(request.Dimensions) + len(request.Metrics)
+	for rows.Next() {
+		values := make([]any, size)
+		pointers := make([]any, size)
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := ReportRow{}
+		for i, name := range request.Dimensions {
+			value := values[i]
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			if d := rp.dimensions[name]; len(d.Bucket) > 0 && value != nil {
+				loc := d.Location
+				if loc == nil {
+					loc = time.Local
+				}
+				if value, err = time.ParseInLocation("2006-01-02 15:04:05", fmt.Sprint(value), loc); err != nil {
+					return nil, err
+				}
+			}
+			row.Dimensions = append(row.Dimensions, value)
+		}
+		for _, value := range values[len(request.Dimensions):] {
+			row.Metrics = append(row.Metrics, toFloat(value))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	if request.PageSize <= 0 {
+		table.Total = int64(len(table.Rows))
+	}
+	return table, rows.Err()
+}
+
+// NULL返回nil
+func toFloat(value any) *float64 {
+	var f float64
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case int64:
+		f = float64(v)
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case []byte:
+		f, _ = strconv.ParseFloat(string(v), 64)
+	case string:
+		f, _ = strconv.ParseFloat(v, 64)
+	}
+	return &f
+}
+
+// 按名称取第i行的维度或指标
+func (t *ReportTable) Value(i int, name string) (any, bool) {
+	for j, d := range t.Dimensions {
+		if d == name {
+			return t.Rows[i].Dimensions[j], true
+		}
+	}
+	for j, m := range t.Metrics {
+		if m == name {
+			if value := t.Rows[i].Metrics[j]; value != nil {
+				return *value, true
+			}
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func formatReportValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// 输出为CSV,第一行为列名
+func (t *ReportTable) CSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append(append([]string{}, t.Dimensions...), t.Metrics...)); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := make([]string, 0, len(row.Dimensions)+len(row.Metrics))
+		for _, value := range row.Dimensions {
+			record = append(record, formatReportValue(value))
+		}
+		for _, value := range row.Metrics {
+			record = append(record, formatReportValue(value))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// 透视,row维度的值作为行,column维度的每个值作为一个指标列,值为metric,没有数据的格为nil
+// 如按日期、状态统计的订单数,Pivot("day", "status", "orders")得到每天一行、每个状态一列
+// 报表只能有row、column两个维度,有其它维度时无法合并平均值、比值等指标,返回错误
+func (t *ReportTable) Pivot(row, column, metric string) (*ReportTable, error) {
+	if len(t.Dimensions) != 2 || row == column {
+		return nil, fmt.Errorf("gorme: pivot requires exactly the dimensions %s and %s, got %v", row, column, t.Dimensions)
+	}
+	rowIndex, columnIndex, metricIndex := -1, -1, -1
+	for i, d := range t.Dimensions {
+		switch d {
+		case row:
+			rowIndex = i
+		case column:
+			columnIndex = i
+		}
+	}
+	for i, m := range t.Metrics {
+		if m == metric {
+			metricIndex = i
+		}
+	}
+	if rowIndex < 0 || columnIndex < 0 || metricIndex < 0 {
+		return nil, fmt.Errorf("gorme: cannot pivot %s by %s on %s", row, column, metric)
+	}
+
+	pivot := &ReportTable{Dimensions: []string{row}}
+	columns := map[string]int{}
+	rows := map[string]int{}
+	for _, r := range t.Rows {
+		name := formatReportValue(r.Dimensions[columnIndex])
+		if _, ok := columns[name]; !ok {
+			columns[name] = len(pivot.Metrics)
+			pivot.Metrics = append(pivot.Metrics, name)
+		}
+	}
+	sort.Strings(pivot.Metrics)
+	for i, name := range pivot.Metrics {
+		columns[name] = i
+	}
+	for _, r := range t.Rows {
+		key := formatReportValue(r.Dimensions[rowIndex])
+		i, ok := rows[key]
+		if !ok {
+			i = len(pivot.Rows)
+			rows[key] = i
+			pivot.Rows = append(pivot.Rows, ReportRow{Dimensions: []any{r.Dimensions[rowIndex]}, Metrics: make([]*float64, len(pivot.Metrics))})
+		}
+		pivot.Rows[i].Metrics[columns[formatReportValue(r.Dimensions[columnIndex])]] = r.Metrics[metricIndex]
+	}
+	pivot.Total = int64(len(pivot.Rows))
+	return pivot, nil
+}
@@ -1,11 +1,13 @@
 package gorme
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"strings"
+	"time"
 )
 
 type Model interface {
@@ -20,6 +22,46 @@ type Repository[T Model] struct {
 	Query *sql.DB
 	//在增删改查时，存放的待处理数据
 	Data map[string]any
+	//软删除策略
+	softDelete SoftDeletePolicy
+	//更新/删除的最大影响行数
+	maxAffectedRows int64
+	//加载数据的原始值,用于SaveChanges,未开启时为nil
+	tracker *tracker
+	//事件总线,为nil时使用全局的Events
+	events *EventBus
+	//是否写入审计日志
+	audit bool
+	//写入时填充字段的值,为nil时不填充
+	filler FieldFiller
+	//数据权限策略只报告不过滤
+	policyDryRun   bool
+	policyReporter func(ctx context.Context, report PolicyReport)
+	//列的访问策略
+	fieldPolicies []FieldPolicy
+	//当前查询的临时状态,终结方法执行后清空
+	state queryState
+}
+
+type queryState struct {
+	//仓库级策略是否已应用到当前查询
+	prepared       bool
+	trashed        trashedScope
+	allowFullTable bool
+	ignoreTenant   bool
+	debug          bool
+	//Joins的联表,用于UPDATE/DELETE
+	joins []clause.Expr
+	//WithoutGlobalScope排除的全局范围
+	withoutScopes    map[string]bool
+	withoutAllScopes bool
+	//WithContext之前的context,重置时恢复
+	withContext bool
+	baseContext context.Context
+	//GroupByTime的分组
+	timeGroup *timeGroup
+	//Row/Rows返回原始数据,脱敏的列不查询
+	rawRows bool
 }
 
 type Setter struct {
@@ -34,8 +76,30 @@ func (s Setter) Set(key string, value any) Setter {
 	return s
 }
 
+// 自增,如 stock = stock + 1
+func (s Setter) Inc(column string, n any) Setter {
+	return s.Set(column, gorm.Expr("? + ?", clause.Column{Name: column}, n))
+}
+
+// 自减,如 stock = stock - 1
+func (s Setter) Dec(column string, n any) Setter {
+	return s.Set(column, gorm.Expr("? - ?", clause.Column{Name: column}, n))
+}
+
+// 设置为SQL表达式,如 SetExpr("amount", "amount * ?", 2)
+func (s Setter) SetExpr(column string, sql string, args ...any) Setter {
+	return s.Set(column, gorm.Expr(sql, args...))
+}
+
+// 设置为NULL
+func (s Setter) SetNull(column string) Setter {
+	return s.Set(column, nil)
+}
+
 func (r *Repository[T]) SetDB(db *gorm.DB) *Repository[T] {
 	r.DB = db
+	//创建仓库时登记租户表,联表时是否加租户条件不依赖仓库的使用顺序
+	r.tenantColumn()
 	return r
 }
 
@@ -45,17 +109,56 @@ func (r *Repository[T]) Reset() *Repository[T] {
 	r.DB.Statement = &oldStatement
 	r.DB.Statement.Clauses = map[string]clause.Clause{}
 	r.DB.Statement.Preloads = map[string][]interface{}{}
+	r.DB.Statement.Unscoped = false
+	r.DB.Statement.Joins = nil
+	r.DB.Statement.Selects = nil
+	r.DB.Statement.Omits = nil
+	r.DB.Statement.Distinct = false
+	r.DB.Statement.SQL = strings.Builder{}
+	r.DB.Statement.Vars = nil
+	r.DB.Error = nil
+	r.DB.RowsAffected = 0
+	r.resetState()
 	return r
 }
 
+// 清空当前查询的临时状态,恢复WithContext之前的context
+func (r *Repository[T]) resetState() {
+	if r.state.withContext {
+		r.DB.Statement.Context = r.state.baseContext
+	}
+	r.state = queryState{}
+}
+
+// 终结方法执行前调用,把仓库级的策略应用到当前查询,同一次查询只应用一次
+func (r *Repository[T]) prepare() {
+	if r.state.prepared {
+		return
+	}
+	r.state.prepared = true
+	r.groupWhere()
+	r.applyGlobalScopes()
+	r.applySoftDelete()
+	r.applyTenant()
+	r.applyDataPolicies()
+	r.applyFieldPolicies()
+}
+
 func (r *Repository[T]) First() (T, error) {
 	var t T
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.First(&t).Error
 	//把DB初始化
 	r.Reset()
+	if err == nil {
+		r.maskRows(access, &t)
+		r.track(&t)
+	}
 	return t, r.IgnoreError(err)
 }
 
+// 忽略记录不存在的错误,其它错误按方言转换为DBError
 func (r *Repository[T]) IgnoreError(err error) error {
 	if err == nil {
 		return nil
@@ -64,14 +167,20 @@ func (r *Repository[T]) IgnoreError(err error) error {
 	if err == gorm.ErrRecordNotFound {
 		return nil
 	}
-	return err
+	return r.translateError(err)
 }
 
 func (r *Repository[T]) Last() (T, error) {
 	var t T
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Last(&t).Error
 	//把DB初始化
 	r.Reset()
+	if err == nil {
+		r.maskRows(access, &t)
+		r.track(&t)
+	}
 	return t, r.IgnoreError(err)
 }
 
@@ -81,9 +190,15 @@ func (r *Repository[T]) GetOne() (T, error) {
 
 func (r *Repository[T]) Take() (T, error) {
 	var t T
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Take(&t).Error
 	//把DB初始化
 	r.Reset()
+	if err == nil {
+		r.maskRows(access, &t)
+		r.track(&t)
+	}
 	return t, r.IgnoreError(err)
 }
 
@@ -94,24 +209,39 @@ func (r *Repository[T]) Values(column ...string) ([]any, error) {
 	}
 
 	var values []any
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Pluck(pluckColumn, &values).Error
 	//把DB初始化
 	r.Reset()
+	if err == nil {
+		values, err = r.maskValues(access, pluckColumn, values)
+	}
 	return values, r.IgnoreError(err)
 }
 
 func (r *Repository[T]) DistinctValues(column string) ([]any, error) {
 	var values []any
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Distinct(column).Pluck(column, &values).Error
 	//把DB初始化
 	r.Reset()
+	if err == nil {
+		values, err = r.maskValues(access, column, values)
+	}
 	return values, r.IgnoreError(err)
 }
 
 func (r *Repository[T]) Pluck(column string) ([]any, error) {
 	var values []any
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Pluck(column, &values).Error
 	r.Reset()
+	if err == nil {
+		values, err = r.maskValues(access, column, values)
+	}
 	return values, r.IgnoreError(err)
 }
 
@@ -125,16 +255,28 @@ func (r *Repository[T]) List(args ...int) ([]T, error) {
 		limit := args[0]
 		r.DB = r.DB.Limit(limit)
 	}
+	r.prepare()
+	access := r.fieldAccess()
 	err := r.DB.Find(&t).Error
 	//DB初始化
 	r.Reset()
+	for i := range t {
+		r.maskRows(access, &t[i])
+		r.track(&t[i])
+	}
 	return t, r.IgnoreError(err)
 }
 
 func (r *Repository[T]) Paginate(pageNo int, pageSize int) (*PageResult[T], error) {
+	r.prepare()
+	access := r.fieldAccess()
 	result, err := Paginate[T](r.DB, pageNo, pageSize)
 	//把DB初始化
 	r.Reset()
+	if result != nil {
+		r.maskRows(access, result.List...)
+		r.track(result.List...)
+	}
 	return result, r.IgnoreError(err)
 }
 
@@ -148,6 +290,7 @@ func (r *Repository[T]) NewQueryBuilder() *gorm.DB {
 
 func (r *Repository[T]) NewQuery() *Repository[T] {
 	var t T
+	r.resetState()
 	r.DB.Statement.Clauses = map[string]clause.Clause{}
 	r.DB = r.DB.Model(&t).Table(t.TableName())
 	return r
@@ -172,116 +315,271 @@ func (r *Repository[T]) NewModel() *T {
 	return t
 }
 
-//======================================最后调用的方法返回*gorm.DB,这样获取结果中的信息更方便一些=====================================
+//======================================写操作返回*WriteResult,执行后重置查询=====================================
 
-func (r *Repository[T]) Create(value interface{}) *gorm.DB {
-	tx := r.DB.Create(value)
-	return tx
+func (r *Repository[T]) Create(value interface{}) *WriteResult {
+	if result := r.guardInsert(value); result != nil {
+		return result
+	}
+	r.fillRows(r.rowsOf(value), fillCreate)
+	result := r.execWrite("create", value, 0, r.audited("create", value, func(db *gorm.DB) *gorm.DB {
+		return db.Create(value)
+	}))
+	r.publish(result, Created[T]{Rows: r.rowsOf(value), Result: result})
+	return result
+}
+
+// 保存,有主键时发布Updated事件,否则发布Created事件
+func (r *Repository[T]) Save(value interface{}) *WriteResult {
+	rows := r.rowsOf(value)
+	r.fillRows(rows, fillSave)
+	r.omitProtected()
+	created := len(rows) == 0
+	//最后一条没有主键的数据,保存后读取生成的自增主键
+	var inserted *T
+	for _, row := range rows {
+		if !r.hasPrimaryKey(row) {
+			created = true
+			inserted = row
+		}
+	}
+	var columns []string
+	if !created {
+		columns = r.savedColumns()
+	}
+
+	exec := func(db *gorm.DB) *gorm.DB {
+		return db.Save(value)
+	}
+	if _, _, scoped, _ := r.tenant(); scoped && len(rows) > 0 {
+		exec = r.tenantSave(rows)
+	}
+	result := r.execWrite("save", value, 0, r.audited("save", value, exec))
+	if result.Error == nil && inserted != nil {
+		result.LastInsertId = r.lastInsertId(inserted)
+	}
+	if created {
+		r.publish(result, Created[T]{Rows: rows, Result: result})
+	} else {
+		r.publish(result, Updated[T]{Rows: rows, Columns: columns, Result: result})
+	}
+	return result
 }
 
-func (r *Repository[T]) Save(value interface{}) *gorm.DB {
-	tx := r.DB.Save(value)
-	return tx
+func (r *Repository[T]) Updates(values interface{}) *WriteResult {
+	if result := r.guardColumns(values); result != nil {
+		return result
+	}
+	values = r.fillUpdates(values)
+	columns := r.updatedColumns(values)
+	result := r.updates(values)
+	r.publish(result, Updated[T]{Rows: r.rowsOf(values), Columns: columns, Result: result})
+	return result
 }
 
-func (r *Repository[T]) Updates(values interface{}) *gorm.DB {
-	var tx *gorm.DB
+func (r *Repository[T]) updates(values interface{}) *WriteResult {
 	if setter, ok := values.(Setter); ok {
-		tx = r.DB.Updates(setter.Data)
-	} else {
-		tx = r.DB.Updates(values)
+		values = setter.Data
 	}
-	r.Reset()
-	return tx
+	if data, ok := values.(map[string]any); ok {
+		var err error
+		if values, err = r.encryptMap(data); err != nil {
+			r.Reset()
+			return &WriteResult{Error: err}
+		}
+		if len(r.state.joins) > 0 {
+			return r.joinedUpdate(values.(map[string]any), true)
+		}
+	}
+	return r.guardedWrite("update", values, func(db *gorm.DB) *gorm.DB {
+		return db.Updates(values)
+	})
 }
 
-func (r *Repository[T]) Update(column string, value interface{}) *gorm.DB {
+func (r *Repository[T]) Update(column string, value interface{}) *WriteResult {
 	var t T
-	tx := r.DB.Update(column, value).Model(&t)
-	r.Reset()
-	return tx
+	if result := r.guardColumns(map[string]any{column: value}); result != nil {
+		return result
+	}
+	//有填充的字段或盲索引时一起更新
+	if data := r.fillMap(map[string]any{column: value}); len(data) > 1 || len(r.encryptedFields()) > 0 {
+		return r.Updates(data)
+	}
+	columns := r.updatedColumns(map[string]any{column: value})
+	var result *WriteResult
+	if len(r.state.joins) > 0 {
+		result = r.joinedUpdate(map[string]any{column: value}, true)
+	} else {
+		result = r.guardedWrite("update", nil, func(db *gorm.DB) *gorm.DB {
+			return db.Update(column, value).Model(&t)
+		})
+	}
+	r.publish(result, Updated[T]{Columns: columns, Result: result})
+	return result
 }
 
-func (r *Repository[T]) UpdateColumn(column string, value interface{}) *gorm.DB {
+func (r *Repository[T]) UpdateColumn(column string, value interface{}) *WriteResult {
+	if result := r.guardColumns(map[string]any{column: value}); result != nil {
+		return result
+	}
+	columns := r.updatedColumns(map[string]any{column: value})
+	result := r.updateColumn(column, value)
+	r.publish(result, Updated[T]{Columns: columns, Result: result})
+	return result
+}
+
+func (r *Repository[T]) updateColumn(column string, value interface{}) *WriteResult {
 	var t T
-	tx := r.DB.Model(&t).UpdateColumn(column, value)
-	r.Reset()
-	return tx
+	if len(r.state.joins) > 0 {
+		return r.joinedUpdate(map[string]any{column: value}, false)
+	}
+	return r.guardedWrite("update", nil, func(db *gorm.DB) *gorm.DB {
+		return db.Model(&t).UpdateColumn(column, value)
+	})
 }
 
-func (r *Repository[T]) UpdateColumns(values interface{}) *gorm.DB {
-	tx := r.DB.UpdateColumns(values)
-	r.Reset()
-	return tx
+// 按当前条件原子自增,返回影响行数
+// UPDATE `tb_order` SET `amount`=`amount` + 1,`updated_at`='2023-01-03 10:21:05.12' WHERE id=1
+func (r *Repository[T]) Increment(column string, n any) (int64, error) {
+	tx := r.Updates(r.NewSetter().Inc(column, n))
+	return tx.RowsAffected, tx.Error
 }
 
-func (r *Repository[T]) Delete(conds ...interface{}) *gorm.DB {
-	var t T
-	tx := r.DB.Unscoped().Delete(&t, conds...)
-	r.Reset()
-	return tx
+// 按当前条件原子自减,返回影响行数
+func (r *Repository[T]) Decrement(column string, n any) (int64, error) {
+	tx := r.Updates(r.NewSetter().Dec(column, n))
+	return tx.RowsAffected, tx.Error
 }
 
-// 软删除,前提是有 Deleted gorm.DeletedAt
-func (r *Repository[T]) DeleteSoft(conds ...interface{}) *gorm.DB {
+func (r *Repository[T]) UpdateColumns(values interface{}) *WriteResult {
+	if result := r.guardColumns(values); result != nil {
+		return result
+	}
+	columns := r.updatedColumns(values)
+	var result *WriteResult
+	if data, ok := values.(map[string]any); ok && len(r.state.joins) > 0 {
+		result = r.joinedUpdate(data, false)
+	} else {
+		result = r.guardedWrite("update", values, func(db *gorm.DB) *gorm.DB {
+			return db.UpdateColumns(values)
+		})
+	}
+	r.publish(result, Updated[T]{Rows: r.rowsOf(values), Columns: columns, Result: result})
+	return result
+}
+
+// 删除,设置了软删除策略时为软删除,否则为物理删除
+func (r *Repository[T]) Delete(conds ...interface{}) *WriteResult {
+	if r.softDelete.Mode != SoftDeleteNone {
+		return r.markDeleted(conds...)
+	}
+	return r.ForceDelete(conds...)
+}
+
+// 软删除,未设置软删除策略时前提是有 Deleted gorm.DeletedAt
+func (r *Repository[T]) DeleteSoft(conds ...interface{}) *WriteResult {
+	if r.softDelete.Mode != SoftDeleteNone {
+		return r.markDeleted(conds...)
+	}
 	var t T
-	tx := r.DB.Delete(&t, conds...)
-	r.Reset()
-	return tx
+	r.whereConds(conds)
+	if len(r.state.joins) > 0 {
+		//gorm的软删除SET的列不带表名,联表时自己更新
+		if field := r.deletedAtField(); field != nil {
+			result := r.joinedUpdate(map[string]any{field.DBName: time.Now()}, false)
+			r.publish(result, Deleted[T]{Soft: true, Result: result})
+			return result
+		}
+	}
+	result := r.guardedWrite("delete", nil, func(db *gorm.DB) *gorm.DB {
+		if len(r.state.joins) > 0 {
+			db = r.joinedDeleteDB(db)
+		}
+		return db.Delete(&t)
+	})
+	r.publish(result, Deleted[T]{Soft: r.deletedAtField() != nil, Result: result})
+	return result
+}
+
+//======================================以下返回*gorm.DB的方法在新的会话上执行,不受重置影响=====================================
+
+// 新的会话,携带当前的查询条件
+func (r *Repository[T]) session() *gorm.DB {
+	return r.DB.Session(&gorm.Session{})
 }
 
+// 开启事务,事务中写入时SubscribeAfterCommit的订阅者等Commit后调用
 func (r *Repository[T]) Begin(opts ...*sql.TxOptions) *gorm.DB {
-	tx := r.DB.Begin(opts...)
+	tx := r.session().Begin(opts...)
 	r.Reset()
+	if tx.Error == nil {
+		tx, _ = withAfterCommit(tx)
+	}
 	return tx
 }
 
+// 提交事务,提交成功后调用SubscribeAfterCommit的订阅者
 func (r *Repository[T]) Commit() *gorm.DB {
-	tx := r.DB.Commit()
+	queue := afterCommitOf(r.DB)
+	tx := r.session().Commit()
 	r.Reset()
+	queue.finish(tx.Error == nil)
 	return tx
 }
 
 func (r *Repository[T]) Rollback() *gorm.DB {
-	tx := r.DB.Rollback()
+	queue := afterCommitOf(r.DB)
+	tx := r.session().Rollback()
 	r.Reset()
+	queue.finish(false)
 	return tx
 }
 
+// 脱敏的列Scan后替换值,隐藏的列不查询
 func (r *Repository[T]) Scan(dest interface{}) *gorm.DB {
-	tx := r.DB.Scan(dest)
+	access := r.fieldAccess()
+	r.prepare()
+	tx := r.session().Scan(dest)
+	tx.Error = r.translateError(tx.Error)
 	r.Reset()
+	if tx.Error == nil {
+		r.maskScanned(access, dest)
+	}
 	return tx
 }
 
 func (r *Repository[T]) ScanRows(rows *sql.Rows, dest interface{}) error {
 	err := r.DB.ScanRows(rows, dest)
 	r.Reset()
-	return err
+	return r.translateError(err)
 }
 
-func (r *Repository[T]) Exec(sql string, values ...interface{}) *gorm.DB {
-	tx := r.DB.Exec(sql, values...)
-	r.Reset()
-	return tx
+func (r *Repository[T]) Exec(sql string, values ...interface{}) *WriteResult {
+	return r.execSQL("exec", sql, values)
 }
 
 func (r *Repository[T]) Raw(sql string, values ...interface{}) *gorm.DB {
-	tx := r.DB.Raw(sql, values...)
+	tx := r.session().Raw(sql, values...)
 	r.Reset()
 	return tx
 }
 
+// 返回原始数据,隐藏和脱敏的列不查询
 func (r *Repository[T]) Row() *sql.Row {
+	r.state.rawRows = true
+	r.prepare()
 	row := r.DB.Row()
 	r.Reset()
 	return row
 }
 
+// 返回原始数据,隐藏和脱敏的列不查询
 func (r *Repository[T]) Rows() (*sql.Rows, error) {
+	r.state.rawRows = true
+	r.prepare()
 	rows, err := r.DB.Rows()
 	r.Reset()
-	return rows, err
+	return rows, r.translateError(err)
 }
 
 // -------------------以下Where查询方式-------------------------
@@ -313,15 +611,24 @@ func (r *Repository[T]) OrWhere(query any, args ...interface{}) *Repository[T] {
 				return r.OrWhereIn(queryStr, args[1])
 			}
 		}
+		//加密列按盲索引查询,只能做等值比较
+		queryStr, value, ok := r.blindIndexCondition(queryStr, expr, value)
+		if !ok {
+			return r
+		}
 		queryExpr := queryStr + expr + "?"
 		r.DB = r.DB.Or(queryExpr, value)
 	case func():
 		f, _ := query.(func())
 		tmp := *r.DB
 		oldDB := &tmp
+		state := r.state
 		r.Reset()
 		f()
 		r.DB = oldDB.Or(r.DB)
+		//Statement中的DB仍指向闭包内使用的DB,需要指回来
+		r.DB.Statement.DB = r.DB
+		r.state = state
 	}
 	return r
 }
@@ -357,17 +664,26 @@ func (r *Repository[T]) Where(query any, args ...interface{}) *Repository[T] {
 				return r.WhereIn(queryStr, args[1])
 			}
 		}
+		//加密列按盲索引查询,只能做等值比较
+		queryStr, value, ok := r.blindIndexCondition(queryStr, expr, value)
+		if !ok {
+			return r
+		}
 		queryExpr := queryStr + expr + "?"
 		r.DB = r.DB.Where(queryExpr, value)
 	case func():
 		f, _ := query.(func())
 		tmp := *r.DB
 		oldDB := &tmp
+		state := r.state
 		r.Reset()
 		fmt.Println(oldDB, r.DB)
 
 		f()
 		r.DB = oldDB.Where(r.DB)
+		//Statement中的DB仍指向闭包内使用的DB,需要指回来
+		r.DB.Statement.DB = r.DB
+		r.state = state
 	}
 	return r
 }
@@ -380,6 +696,9 @@ func (r *Repository[T]) WhereIn(column string, args interface{}) *Repository[T]
 	default:
 		values = args
 	}
+	if index, ok := r.blindIndexColumn(column); ok {
+		column, values = index, r.blindIndexValues(values)
+	}
 	r.DB = r.DB.Where(column+" IN(?) ", values)
 	return r
 }
@@ -392,6 +711,9 @@ func (r *Repository[T]) OrWhereIn(column string, args interface{}) *Repository[T
 	default:
 		values = args
 	}
+	if index, ok := r.blindIndexColumn(column); ok {
+		column, values = index, r.blindIndexValues(values)
+	}
 	r.DB = r.DB.Or(column+" IN(?) ", values)
 	return r
 }
@@ -404,6 +726,9 @@ func (r *Repository[T]) WhereNotIn(column string, args interface{}) *Repository[
 	default:
 		values = args
 	}
+	if index, ok := r.blindIndexColumn(column); ok {
+		column, values = index, r.blindIndexValues(values)
+	}
 	r.DB = r.DB.Where(column+" NOT IN(?) ", values)
 	return r
 }
@@ -422,71 +747,105 @@ func (r *Repository[T]) FindInSet(column string, set any) *Repository[T] {
 }
 
 func (r *Repository[T]) Between(column string, value1, value2 any) *Repository[T] {
-	r.DB = r.DB.Where(column+" BETWEEN ? AND ? ", value1, value2)
+	if _, _, ok := r.blindIndexCondition(column, "BETWEEN", nil); ok {
+		r.DB = r.DB.Where(column+" BETWEEN ? AND ? ", value1, value2)
+	}
 	return r
 }
 
 func (r *Repository[T]) NotBetween(column string, value1, value2 any) *Repository[T] {
-	r.DB = r.DB.Where(column+" NOT BETWEEN ? AND ? ", value1, value2)
+	if _, _, ok := r.blindIndexCondition(column, "NOT BETWEEN", nil); ok {
+		r.DB = r.DB.Where(column+" NOT BETWEEN ? AND ? ", value1, value2)
+	}
 	return r
 }
 
 func (r *Repository[T]) Eq(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" =? ", value)
+	key, value, ok := r.blindIndexCondition(key, "=", value)
+	if ok {
+		r.DB = r.DB.Where(key+" =? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Neq(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" !=? ", value)
+	key, value, ok := r.blindIndexCondition(key, "!=", value)
+	if ok {
+		r.DB = r.DB.Where(key+" !=? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Gt(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" >? ", value)
+	key, value, ok := r.blindIndexCondition(key, ">", value)
+	if ok {
+		r.DB = r.DB.Where(key+" >? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Ge(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" >=? ", value)
+	key, value, ok := r.blindIndexCondition(key, ">=", value)
+	if ok {
+		r.DB = r.DB.Where(key+" >=? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Lt(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" <? ", value)
+	key, value, ok := r.blindIndexCondition(key, "<", value)
+	if ok {
+		r.DB = r.DB.Where(key+" <? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Le(key string, value any) *Repository[T] {
-	r.DB = r.DB.Where(key+" <=? ", value)
+	key, value, ok := r.blindIndexCondition(key, "<=", value)
+	if ok {
+		r.DB = r.DB.Where(key+" <=? ", value)
+	}
 	return r
 }
 
 func (r *Repository[T]) Like(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" LIKE ? ", "%"+value+"%")
+	if _, _, ok := r.blindIndexCondition(key, "LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" LIKE ? ", "%"+value+"%")
+	}
 	return r
 }
 
 func (r *Repository[T]) LikeLeft(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" LIKE ? ", "%"+value)
+	if _, _, ok := r.blindIndexCondition(key, "LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" LIKE ? ", "%"+value)
+	}
 	return r
 }
 
 func (r *Repository[T]) LikeRight(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" LIKE ? ", value+"%")
+	if _, _, ok := r.blindIndexCondition(key, "LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" LIKE ? ", value+"%")
+	}
 	return r
 }
 
 func (r *Repository[T]) NotLike(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" NOT LIKE ? ", "%"+value+"%")
+	if _, _, ok := r.blindIndexCondition(key, "NOT LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" NOT LIKE ? ", "%"+value+"%")
+	}
 	return r
 }
 
 func (r *Repository[T]) NotLikeLeft(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" NOT LIKE ? ", "%"+value)
+	if _, _, ok := r.blindIndexCondition(key, "NOT LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" NOT LIKE ? ", "%"+value)
+	}
 	return r
 }
 func (r *Repository[T]) NotLikeRight(key string, value string) *Repository[T] {
-	r.DB = r.DB.Where(key+" NOT LIKE ? ", value+"%")
+	if _, _, ok := r.blindIndexCondition(key, "NOT LIKE", nil); ok {
+		r.DB = r.DB.Where(key+" NOT LIKE ? ", value+"%")
+	}
 	return r
 }
 
@@ -549,6 +908,7 @@ func (r *Repository[T]) Attrs(attrs ...interface{}) *Repository[T] {
 
 func (r *Repository[T]) Joins(query string, args ...interface{}) *Repository[T] {
 	r.DB = r.DB.Joins(query, args...)
+	r.state.joins = append(r.state.joins, clause.Expr{SQL: query, Vars: args})
 	return r
 }
 
@@ -562,8 +922,19 @@ func (r *Repository[T]) Having(query interface{}, args ...interface{}) *Reposito
 	return r
 }
 
+// 当前查询使用ctx,终结方法执行后恢复
+func (r *Repository[T]) WithContext(ctx context.Context) *Repository[T] {
+	if !r.state.withContext {
+		r.state.withContext = true
+		r.state.baseContext = r.DB.Statement.Context
+	}
+	r.DB = r.DB.WithContext(ctx)
+	return r
+}
+
 func (r *Repository[T]) Debug() *Repository[T] {
 	r.DB = r.DB.Debug()
+	r.state.debug = true
 	return r
 }
 
@@ -578,20 +949,40 @@ func (r *Repository[T]) Clauses(conds ...clause.Expression) *Repository[T] {
 }
 
 func (r *Repository[T]) Count(count *int64) *Repository[T] {
+	r.prepare()
 	r.DB = r.DB.Count(count)
 	return r
 }
 
+// 查询时应用租户、范围和策略,创建的数据设置租户
 func (r *Repository[T]) FirstOrCreate(dest interface{}, conds ...interface{}) *Repository[T] {
+	if !r.prepareFirstOr(dest) {
+		return r
+	}
 	r.DB = r.DB.FirstOrCreate(dest, conds...)
 	return r
 }
 
 func (r *Repository[T]) FirstOrInit(dest interface{}, conds ...interface{}) *Repository[T] {
+	if !r.prepareFirstOr(dest) {
+		return r
+	}
 	r.DB = r.DB.FirstOrInit(dest, conds...)
 	return r
 }
 
+func (r *Repository[T]) prepareFirstOr(dest interface{}) bool {
+	if row, ok := dest.(*T); ok {
+		if err := r.tenantRows([]*T{row}); err != nil {
+			r.DB = r.DB.Session(&gorm.Session{})
+			r.DB.AddError(err)
+			return false
+		}
+	}
+	r.prepare()
+	return true
+}
+
 func (r *Repository[T]) Table(name string, args ...interface{}) *Repository[T] {
 	r.DB = r.DB.Table(name, args...)
 	return r
@@ -617,8 +1008,18 @@ func (r *Repository[T]) Not(query interface{}, args ...interface{}) *Repository[
 	return r
 }
 
+// 事务,提交成功后调用SubscribeAfterCommit的订阅者;嵌套在外层事务中时由外层提交
 func (r *Repository[T]) Transaction(fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
-	return r.DB.Transaction(fc, opts...)
+	if _, nested := r.DB.Statement.ConnPool.(gorm.TxCommitter); nested {
+		return r.translateError(r.DB.Transaction(fc, opts...))
+	}
+	var queue *afterCommitQueue
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		tx, queue = withAfterCommit(tx)
+		return fc(tx)
+	}, opts...)
+	queue.finish(err == nil)
+	return r.translateError(err)
 }
 
 func (r *Repository[T]) Unscoped() *Repository[T] {
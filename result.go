@@ -0,0 +1,173 @@
+package gorme
+
+import (
+	"context"
+	"database/sql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 写操作的结果
+type WriteResult struct {
+	RowsAffected int64  //影响行数
+	LastInsertId int64  //插入时生成的自增主键,批量插入时为最后一条,原生SQL插入时为驱动返回的值
+	Error        error  //错误
+	SQL          string //Debug()模式下执行的SQL,多条时以分号分隔
+	ctx          context.Context
+}
+
+// 记录执行的SQL
+type sqlRecorder struct {
+	logger.Interface
+	sql *[]string
+}
+
+func (l sqlRecorder) LogMode(level logger.LogLevel) logger.Interface {
+	return sqlRecorder{Interface: l.Interface.LogMode(level), sql: l.sql}
+}
+
+func (l sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, rows := fc()
+	*l.sql = append(*l.sql, sql)
+	l.Interface.Trace(ctx, begin, func() (string, int64) {
+		return sql, rows
+	}, err)
+}
+
+// 执行写操作并生成WriteResult,执行后重置查询
+// value为写入的数据,用于设置租户、盲索引、校验枚举和读取自增主键;limit大于0时在事务中执行,影响行数超过limit时回滚
+func (r *Repository[T]) execWrite(operation string, value any, limit int64, exec func(db *gorm.DB) *gorm.DB) *WriteResult {
+	//新增的数据设置租户,按模型更新时不改租户列
+	if operation != "update" {
+		if err := r.tenantRows(r.rowsOf(value)); err != nil {
+			r.Reset()
+			return &WriteResult{Error: err}
+		}
+	}
+	//加密列的盲索引,按模型更新时只处理有值的列
+	if err := r.blindIndexRows(r.rowsOf(value), operation != "update"); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	if operation == "update" {
+		r.selectBlindIndexes()
+	}
+	if err := r.validateEnums(r.rowsOf(value)); err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	//在新的会话上执行,错误不会残留在r.DB上影响后续查询
+	db := r.DB.Session(&gorm.Session{AllowGlobalUpdate: r.state.allowFullTable})
+	var sqls []string
+	if r.state.debug {
+		db = db.Session(&gorm.Session{Logger: sqlRecorder{Interface: db.Logger, sql: &sqls}})
+	}
+
+	var tx *gorm.DB
+	var err error
+	if limit <= 0 {
+		tx = exec(db)
+	} else {
+		err = db.Transaction(func(db *gorm.DB) error {
+			tx = exec(db)
+			if tx.Error != nil {
+				return tx.Error
+			}
+			if tx.RowsAffected > limit {
+				return &AffectedRowsError{Operation: operation, Limit: limit, RowsAffected: tx.RowsAffected}
+			}
+			return nil
+		})
+	}
+
+	//Reset会恢复WithContext之前的context,事件使用执行时的context
+	result := &WriteResult{Error: err, SQL: strings.Join(sqls, ";\n"), ctx: r.DB.Statement.Context}
+	if tx != nil {
+		result.RowsAffected = tx.RowsAffected
+		if tx.Error != nil {
+			result.Error = tx.Error
+		}
+	}
+	if result.Error == nil && inserting(operation) {
+		result.LastInsertId = r.lastInsertId(value)
+	}
+	result.Error = r.translateError(result.Error)
+	r.Reset()
+	return result
+}
+
+// 插入数据的操作,Save按是否有主键在调用处判断
+func inserting(operation string) bool {
+	switch operation {
+	case "create", "upsert", "insert", "replace":
+		return true
+	}
+	return false
+}
+
+// 执行原生SQL,INSERT/REPLACE语句读取驱动返回的自增主键
+func (r *Repository[T]) execSQL(operation string, query string, values []any) *WriteResult {
+	var execResult sql.Result
+	result := r.execWrite(operation, nil, 0, func(db *gorm.DB) *gorm.DB {
+		//带Context的会话会复制Statement,替换连接不影响仓库
+		db = db.Session(&gorm.Session{Context: db.Statement.Context})
+		db.Statement.ConnPool = resultPool{ConnPool: db.Statement.ConnPool, result: &execResult}
+		return db.Exec(query, values...)
+	})
+	if result.Error == nil && execResult != nil && rawInsert.MatchString(query) {
+		if id, err := execResult.LastInsertId(); err == nil {
+			result.LastInsertId = id
+		}
+	}
+	return result
+}
+
+var rawInsert = regexp.MustCompile(`(?i)^\s*(INSERT|REPLACE)\s`)
+
+// 记录Exec的执行结果
+type resultPool struct {
+	gorm.ConnPool
+	result *sql.Result
+}
+
+func (p resultPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := p.ConnPool.ExecContext(ctx, query, args...)
+	if err == nil {
+		*p.result = result
+	}
+	return result, err
+}
+
+// 读取写入数据中的整型主键,value为切片时取最后一条
+func (r *Repository[T]) lastInsertId(value any) int64 {
+	var t T
+	if value == nil {
+		return 0
+	}
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		if rv.Len() == 0 {
+			return 0
+		}
+		rv = reflect.Indirect(rv.Index(rv.Len() - 1))
+	}
+	if !rv.IsValid() || rv.Type() != reflect.TypeOf(t) {
+		return 0
+	}
+	s, err := r.schema()
+	if err != nil || s.PrioritizedPrimaryField == nil {
+		return 0
+	}
+	id := s.PrioritizedPrimaryField.ReflectValueOf(context.Background(), rv)
+	switch id.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return id.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(id.Uint())
+	}
+	return 0
+}
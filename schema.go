@@ -0,0 +1,63 @@
+package gorme
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+// 解析后的模型结构缓存
+var schemaCache = &sync.Map{}
+
+// 解析模型T的结构
+func (r *Repository[T]) schema() (*schema.Schema, error) {
+	var t T
+	return schema.Parse(&t, schemaCache, r.DB.NamingStrategy)
+}
+
+// 解析模型T的结构,没有主键时返回错误
+func (r *Repository[T]) schemaWithPrimaryKey() (*schema.Schema, error) {
+	s, err := r.schema()
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("gorme: model %s has no primary key", s.Name)
+	}
+	return s, nil
+}
+
+// value是否为设置了主键的模型T
+func (r *Repository[T]) hasPrimaryKey(value any) bool {
+	var t T
+	if value == nil {
+		return false
+	}
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if !rv.IsValid() || rv.Type() != reflect.TypeOf(t) {
+		return false
+	}
+	s, err := r.schema()
+	if err != nil || s.PrioritizedPrimaryField == nil {
+		return false
+	}
+	_, isZero := s.PrioritizedPrimaryField.ValueOf(context.Background(), rv)
+	return !isZero
+}
+
+// 模型T中gorm.DeletedAt类型的字段,没有时返回nil
+func (r *Repository[T]) deletedAtField() *schema.Field {
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	for _, field := range s.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return field
+		}
+	}
+	return nil
+}
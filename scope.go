@@ -0,0 +1,123 @@
+package gorme
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sync"
+)
+
+// 按模型类型登记的命名范围
+type scopeRegistry struct {
+	mu     sync.RWMutex
+	local  map[string]any
+	global []namedScope
+}
+
+type namedScope struct {
+	name  string
+	scope any
+}
+
+var scopeRegistries = &sync.Map{}
+
+func scopesOf[T Model]() *scopeRegistry {
+	var t T
+	v, _ := scopeRegistries.LoadOrStore(reflect.TypeOf(t), &scopeRegistry{local: map[string]any{}})
+	return v.(*scopeRegistry)
+}
+
+// 定义命名范围,同一模型的所有仓库共用,通过Scope(name)使用
+// repo.DefineScope("paid", func(r *Repository[OrderModel]) *Repository[OrderModel] { return r.Where("status", "paid") })
+func (r *Repository[T]) DefineScope(name string, scope func(r *Repository[T]) *Repository[T]) *Repository[T] {
+	registry := scopesOf[T]()
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.local[name] = scope
+	return r
+}
+
+// 定义全局范围,同一模型的所有查询、更新、删除自动应用,可用WithoutGlobalScope(name)排除
+func (r *Repository[T]) DefineGlobalScope(name string, scope func(r *Repository[T]) *Repository[T]) *Repository[T] {
+	registry := scopesOf[T]()
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for i, s := range registry.global {
+		if s.name == name {
+			registry.global[i].scope = scope
+			return r
+		}
+	}
+	registry.global = append(registry.global, namedScope{name: name, scope: scope})
+	return r
+}
+
+// 按名称应用DefineScope定义的范围,名称未定义时查询返回错误
+func (r *Repository[T]) Scope(names ...string) *Repository[T] {
+	registry := scopesOf[T]()
+	for _, name := range names {
+		registry.mu.RLock()
+		scope, ok := registry.local[name]
+		registry.mu.RUnlock()
+		if !ok {
+			r.DB = r.DB.Session(&gorm.Session{})
+			r.DB.AddError(fmt.Errorf("gorme: undefined scope %s", name))
+			continue
+		}
+		scope.(func(r *Repository[T]) *Repository[T])(r)
+	}
+	return r
+}
+
+// 当前查询不应用指定的全局范围,不传名称时不应用所有全局范围
+func (r *Repository[T]) WithoutGlobalScope(names ...string) *Repository[T] {
+	if len(names) == 0 {
+		r.state.withoutAllScopes = true
+		return r
+	}
+	if r.state.withoutScopes == nil {
+		r.state.withoutScopes = map[string]bool{}
+	}
+	for _, name := range names {
+		r.state.withoutScopes[name] = true
+	}
+	return r
+}
+
+// 应用全局范围
+func (r *Repository[T]) applyGlobalScopes() {
+	if r.state.withoutAllScopes {
+		return
+	}
+	registry := scopesOf[T]()
+	registry.mu.RLock()
+	scopes := append([]namedScope{}, registry.global...)
+	registry.mu.RUnlock()
+	for _, s := range scopes {
+		if !r.state.withoutScopes[s.name] {
+			s.scope.(func(r *Repository[T]) *Repository[T])(r)
+		}
+	}
+}
+
+// 已有的条件中有OR时,先用括号括起来,避免后加的条件只与最后一个OR结合
+// WHERE a OR b 加上 c 后为 WHERE (a OR b) AND c
+func (r *Repository[T]) groupWhere() {
+	c, ok := r.DB.Statement.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok || len(where.Exprs) < 2 {
+		return
+	}
+	for _, expr := range where.Exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+			c.Expression = where
+			r.DB.Statement.Clauses["WHERE"] = c
+			return
+		}
+	}
+}
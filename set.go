@@ -0,0 +1,129 @@
+package gorme
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+// 逗号分隔存储的集合列,如MySQL的SET类型或"a,b,c"格式的字符串列
+type Set[V comparable] []V
+
+func NewSet[V comparable](values ...V) Set[V] {
+	var s Set[V]
+	s.Add(values...)
+	return s
+}
+
+func (s Set[V]) Contains(value V) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 添加不在集合中的值
+func (s *Set[V]) Add(values ...V) {
+	for _, value := range values {
+		if !s.Contains(value) {
+			*s = append(*s, value)
+		}
+	}
+}
+
+func (s *Set[V]) Remove(values ...V) {
+	removed := Set[V](values)
+	result := (*s)[:0]
+	for _, v := range *s {
+		if !removed.Contains(v) {
+			result = append(result, v)
+		}
+	}
+	*s = result
+}
+
+func (s Set[V]) Value() (driver.Value, error) {
+	parts := make([]string, len(s))
+	for i, v := range s {
+		parts[i] = fmt.Sprint(v)
+		if strings.Contains(parts[i], ",") {
+			return nil, fmt.Errorf("gorme: set value %q contains comma", parts[i])
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (s *Set[V]) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("gorme: cannot scan %T into Set", src)
+	}
+
+	*s = nil
+	if len(text) == 0 {
+		return nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		var v V
+		rv := reflect.ValueOf(&v).Elem()
+		if rv.Kind() == reflect.String {
+			rv.SetString(part)
+		} else if _, err := fmt.Sscan(part, &v); err != nil {
+			return fmt.Errorf("gorme: cannot scan %q into Set: %w", part, err)
+		}
+		*s = append(*s, v)
+	}
+	return nil
+}
+
+func (s Set[V]) GormDataType() string {
+	return "string"
+}
+
+// LIKE中的通配符按普通字符匹配,转义符为反斜杠
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// 集合列中包含某个值的条件,MySQL为FIND_IN_SET,其它数据库按逗号包围后LIKE,值中的%和_已转义
+// SELECT * FROM "tb_order" WHERE (',' || "tags" || ',') LIKE '%,a\_b,%' ESCAPE '\'
+func (r *Repository[T]) inSet(column string, value any) clause.Expression {
+	col := clause.Column{Name: column}
+	if r.DB.Dialector.Name() == "mysql" {
+		return clause.Expr{SQL: "FIND_IN_SET(?, ?)", Vars: []any{fmt.Sprint(value), col}}
+	}
+	return clause.Expr{SQL: "(',' || ? || ',') LIKE ? ESCAPE '\\'", Vars: []any{col, "%," + likeEscaper.Replace(fmt.Sprint(value)) + ",%"}}
+}
+
+// 集合列包含values中的任意一个
+// SELECT * FROM `tb_order` WHERE (FIND_IN_SET('vip',`tags`) OR FIND_IN_SET('new',`tags`))
+func (r *Repository[T]) HasAny(column string, values ...any) *Repository[T] {
+	if len(values) == 0 {
+		return r
+	}
+	conditions := make([]clause.Expression, len(values))
+	for i, value := range values {
+		conditions[i] = r.inSet(column, value)
+	}
+	r.DB = r.DB.Where(clause.Or(conditions...))
+	return r
+}
+
+// 集合列包含values中的所有值
+// SELECT * FROM `tb_order` WHERE FIND_IN_SET('vip',`tags`) AND FIND_IN_SET('new',`tags`)
+func (r *Repository[T]) HasAll(column string, values ...any) *Repository[T] {
+	for _, value := range values {
+		r.DB = r.DB.Where(r.inSet(column, value))
+	}
+	return r
+}
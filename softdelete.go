@@ -0,0 +1,130 @@
+package gorme
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type SoftDeleteMode int
+
+const (
+	//未设置策略,Delete为物理删除,DeleteSoft使用gorm自带的DeletedAt
+	SoftDeleteNone SoftDeleteMode = iota
+	//时间列,NULL表示未删除,如deleted_at
+	SoftDeleteTime
+	//标记列,0表示未删除,1表示已删除,如is_deleted
+	SoftDeleteFlag
+)
+
+// 软删除策略,设置后Delete变为软删除,ForceDelete为物理删除
+type SoftDeletePolicy struct {
+	Mode   SoftDeleteMode
+	Column string //软删除列名,为空时时间列默认deleted_at,标记列默认is_deleted
+}
+
+type trashedScope int
+
+const (
+	trashedExclude trashedScope = iota //默认,不含已删除的数据
+	trashedWith                        //包含已删除的数据
+	trashedOnly                        //只查已删除的数据
+)
+
+func (r *Repository[T]) SetSoftDelete(policy SoftDeletePolicy) *Repository[T] {
+	r.softDelete = policy
+	return r
+}
+
+func (r *Repository[T]) softDeleteColumn() string {
+	if len(r.softDelete.Column) > 0 {
+		return r.softDelete.Column
+	}
+	if r.softDelete.Mode == SoftDeleteFlag {
+		return "is_deleted"
+	}
+	return "deleted_at"
+}
+
+// 未删除时软删除列的值
+func (r *Repository[T]) notDeletedValue() any {
+	if r.softDelete.Mode == SoftDeleteFlag {
+		return 0
+	}
+	return nil
+}
+
+// 删除时写入软删除列的值
+func (r *Repository[T]) deletedValue() any {
+	if r.softDelete.Mode == SoftDeleteFlag {
+		return 1
+	}
+	return time.Now()
+}
+
+// 查询时包含已删除的数据
+func (r *Repository[T]) WithTrashed() *Repository[T] {
+	r.state.trashed = trashedWith
+	return r
+}
+
+// 只查询已删除的数据
+func (r *Repository[T]) OnlyTrashed() *Repository[T] {
+	r.state.trashed = trashedOnly
+	return r
+}
+
+// 根据软删除策略追加条件
+func (r *Repository[T]) applySoftDelete() {
+	column := clause.Column{Table: clause.CurrentTable, Name: r.softDeleteColumn()}
+	if r.softDelete.Mode == SoftDeleteNone {
+		//未设置策略时由gorm的DeletedAt处理未删除条件
+		switch r.state.trashed {
+		case trashedWith:
+			r.DB = r.DB.Unscoped()
+		case trashedOnly:
+			r.DB = r.DB.Unscoped().Where(clause.Neq{Column: column, Value: nil})
+		}
+		return
+	}
+
+	r.DB = r.DB.Unscoped()
+	switch r.state.trashed {
+	case trashedExclude:
+		r.DB = r.DB.Where(clause.Eq{Column: column, Value: r.notDeletedValue()})
+	case trashedOnly:
+		r.DB = r.DB.Where(clause.Neq{Column: column, Value: r.notDeletedValue()})
+	}
+}
+
+// 按软删除策略把数据标记为已删除
+func (r *Repository[T]) markDeleted(conds ...interface{}) *WriteResult {
+	r.whereConds(conds)
+	result := r.updateColumn(r.softDeleteColumn(), r.deletedValue())
+	r.publish(result, Deleted[T]{Soft: true, Result: result})
+	return result
+}
+
+// 恢复已软删除的数据
+func (r *Repository[T]) Restore(conds ...interface{}) *WriteResult {
+	r.whereConds(conds)
+	r.state.trashed = trashedOnly
+	result := r.updateColumn(r.softDeleteColumn(), r.notDeletedValue())
+	r.publish(result, Restored[T]{Result: result})
+	return result
+}
+
+// 物理删除,不受软删除策略影响
+func (r *Repository[T]) ForceDelete(conds ...interface{}) *WriteResult {
+	var t T
+	r.whereConds(conds)
+	r.state.trashed = trashedWith
+	result := r.guardedWrite("delete", nil, func(db *gorm.DB) *gorm.DB {
+		if len(r.state.joins) > 0 {
+			db = r.joinedDeleteDB(db)
+		}
+		return db.Unscoped().Delete(&t)
+	})
+	r.publish(result, Deleted[T]{Result: result})
+	return result
+}
@@ -0,0 +1,265 @@
+package gorme
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 租户隔离的模型在context中没有租户时返回的错误
+var ErrTenantRequired = errors.New("gorme: tenant required in context, use IgnoreTenant() if intended")
+
+// 租户隔离的模型不能使用Replace,冲突时会删除其它租户的行
+var ErrTenantReplace = errors.New("gorme: Replace is not allowed on tenant scoped models, use Upsert instead")
+
+// 租户隔离的模型更新时不能把行改到其它租户
+var ErrTenantUpdate = errors.New("gorme: cannot move rows to another tenant")
+
+// 表名到租户列的映射,用于给联表加租户条件,在仓库SetDB时登记
+var tenantTables = &sync.Map{}
+
+// 模型类型到租户列的缓存
+var tenantColumns = &sync.Map{}
+
+// 登记没有仓库的表的租户列,联表时也会加上租户条件
+// 联表的租户表需要在查询前创建过仓库或通过RegisterTenantTable登记
+func RegisterTenantTable(table, column string) {
+	tenantTables.Store(table, column)
+}
+
+// 当前查询不加租户条件
+func (r *Repository[T]) IgnoreTenant() *Repository[T] {
+	r.state.ignoreTenant = true
+	return r
+}
+
+// 模型中标签为`gorme:"tenant"`的列,没有时为空
+func (r *Repository[T]) tenantColumn() string {
+	var t T
+	typ := reflect.TypeOf(t)
+	if v, ok := tenantColumns.Load(typ); ok {
+		return v.(string)
+	}
+	s, err := r.schema()
+	if err != nil {
+		return ""
+	}
+
+	column := ""
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 {
+			continue
+		}
+		for _, setting := range strings.Split(field.Tag.Get("gorme"), ";") {
+			if strings.TrimSpace(setting) == "tenant" {
+				column = field.DBName
+			}
+		}
+	}
+	tenantColumns.Store(typ, column)
+	if column != "" {
+		tenantTables.Store(s.Table, column)
+	}
+	return column
+}
+
+// 当前查询的租户,不需要租户隔离时scoped为false
+func (r *Repository[T]) tenant() (column string, tenant any, scoped bool, err error) {
+	if r.state.ignoreTenant {
+		return "", nil, false, nil
+	}
+	if column = r.tenantColumn(); column == "" {
+		return "", nil, false, nil
+	}
+	tenant, ok := TenantFromContext(r.DB.Statement.Context)
+	if !ok {
+		return column, nil, true, ErrTenantRequired
+	}
+	return column, tenant, true, nil
+}
+
+// 给查询、更新、删除及联表加上租户条件
+func (r *Repository[T]) applyTenant() {
+	column, tenant, scoped, err := r.tenant()
+	if !scoped {
+		return
+	}
+	if err != nil {
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(err)
+		return
+	}
+	r.DB = r.DB.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: tenant})
+
+	for i, join := range r.DB.Statement.Joins {
+		r.DB.Statement.Joins[i].Name, r.DB.Statement.Joins[i].Conds = tenantJoin(join.Name, join.Conds, tenant)
+	}
+	for i, join := range r.state.joins {
+		r.state.joins[i].SQL, r.state.joins[i].Vars = tenantJoin(join.SQL, join.Vars, tenant)
+	}
+}
+
+// 联表为租户隔离的表时,在ON中加上租户条件
+// LEFT JOIN tb_user u ON u.id=tb_order.user_id 变为 LEFT JOIN tb_user u ON (u.id=tb_order.user_id) AND u.tenant_id = ?
+func tenantJoin(query string, args []any, tenant any) (string, []any) {
+	m := joinPattern.FindStringSubmatchIndex(query)
+	if m == nil {
+		return query, args
+	}
+	tableParts := strings.Fields(query[m[2]:m[3]])
+	table := strings.Trim(tableParts[0], "`\"")
+	v, ok := tenantTables.Load(table)
+	if !ok {
+		return query, args
+	}
+	alias := table
+	if len(tableParts) > 1 {
+		alias = strings.Trim(tableParts[len(tableParts)-1], "`\"")
+	}
+	query = query[:m[4]] + "(" + query[m[4]:m[5]] + ") AND " + alias + "." + v.(string) + " = ?"
+	return query, append(append([]any{}, args...), tenant)
+}
+
+// 给新增的数据设置租户,没有租户时返回错误
+func (r *Repository[T]) tenantRows(rows []*T) error {
+	column, tenant, scoped, err := r.tenant()
+	if !scoped || len(rows) == 0 {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	s, err := r.schema()
+	if err != nil {
+		return err
+	}
+	field := s.LookUpField(column)
+	for _, row := range rows {
+		if err = field.Set(r.DB.Statement.Context, reflect.ValueOf(row).Elem(), tenant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 更新的数据中租户列的值与当前租户不同时返回ErrTenantUpdate,模型中为0值的租户列不检查
+func (r *Repository[T]) checkTenantUpdate(values any) error {
+	column, tenant, scoped, err := r.tenant()
+	if !scoped || err != nil {
+		//没有租户的错误在prepare中返回
+		return nil
+	}
+	s, err := r.schema()
+	if err != nil {
+		return nil
+	}
+	if setter, ok := values.(Setter); ok {
+		values = setter.Data
+	}
+	if data, ok := values.(map[string]any); ok {
+		for name, value := range data {
+			field := s.LookUpField(name[strings.LastIndex(name, ".")+1:])
+			if field != nil && field.DBName == column && fmt.Sprint(value) != fmt.Sprint(tenant) {
+				return ErrTenantUpdate
+			}
+		}
+		return nil
+	}
+	field := s.LookUpField(column)
+	for _, row := range r.rowsOf(values) {
+		value, isZero := field.ValueOf(r.DB.Statement.Context, reflect.ValueOf(row).Elem())
+		if !isZero && fmt.Sprint(value) != fmt.Sprint(tenant) {
+			return ErrTenantUpdate
+		}
+	}
+	return nil
+}
+
+// 按模型更新时不更新租户列,避免Select("*")等把0值写入租户列
+func (r *Repository[T]) omitTenant() {
+	if column, _, scoped, _ := r.tenant(); scoped {
+		r.DB = r.DB.Omit(append(append([]string{}, r.DB.Statement.Omits...), column)...)
+	}
+}
+
+// 租户隔离时冲突只更新本租户的行,不覆盖其它租户的数据,租户列不更新
+// MySQL为 `amount`=IF(`tenant_id` = 1, VALUES(`amount`), `amount`),PostgreSQL和SQLite为 DO UPDATE SET ... WHERE "tb_order"."tenant_id" = 1
+func (r *Repository[T]) tenantConflict(conflict clause.OnConflict) clause.OnConflict {
+	column, tenant, scoped, err := r.tenant()
+	if !scoped || err != nil || conflict.DoNothing {
+		//没有租户的错误在execWrite中返回
+		return conflict
+	}
+	s, err := r.schema()
+	if err != nil {
+		return conflict
+	}
+	if conflict.UpdateAll {
+		conflict.UpdateAll = false
+		conflict.DoUpdates = nil
+		protected := r.protectedColumns()
+		for _, field := range s.Fields {
+			if len(field.DBName) == 0 || field.PrimaryKey || field.AutoCreateTime > 0 || containsString(protected, field.DBName) ||
+				(field.HasDefaultValue && field.DefaultValueInterface == nil && !strings.EqualFold(field.DefaultValue, "NULL")) {
+				continue
+			}
+			conflict.DoUpdates = append(conflict.DoUpdates, clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: Excluded(field.DBName)})
+		}
+	}
+
+	mysql := r.DB.Dialector.Name() == "mysql"
+	updates := make(clause.Set, 0, len(conflict.DoUpdates))
+	for _, assignment := range conflict.DoUpdates {
+		if assignment.Column.Name == column {
+			continue
+		}
+		if c, ok := assignment.Value.(clause.Column); ok && c.Table == "excluded" {
+			assignment.Value = Excluded(c.Name)
+		}
+		if mysql {
+			assignment.Value = clause.Expr{SQL: "IF(? = ?, ?, ?)", Vars: []any{clause.Column{Name: column}, tenant, assignment.Value, clause.Column{Name: assignment.Column.Name}}}
+		}
+		updates = append(updates, assignment)
+	}
+	conflict.DoUpdates = updates
+	if !mysql {
+		conflict.Where = clause.Where{Exprs: append(conflict.Where.Exprs, clause.Eq{Column: clause.Column{Table: s.Table, Name: column}, Value: tenant})}
+	}
+	return conflict
+}
+
+// 租户隔离时的Save,有主键的按主键和租户更新所有列,不会像gorm的Save那样在没有更新到数据时改为插入
+func (r *Repository[T]) tenantSave(rows []*T) func(db *gorm.DB) *gorm.DB {
+	column, tenant, _, _ := r.tenant()
+	return func(db *gorm.DB) *gorm.DB {
+		var rowsAffected int64
+		save := func(db *gorm.DB) error {
+			for _, row := range rows {
+				tx := db.Session(&gorm.Session{NewDB: true})
+				if r.hasPrimaryKey(row) {
+					tx = tx.Model(row).Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: tenant}).Select("*").Save(row)
+				} else {
+					tx = tx.Create(row)
+				}
+				if tx.Error != nil {
+					return tx.Error
+				}
+				rowsAffected += tx.RowsAffected
+			}
+			return nil
+		}
+
+		tx := db.Session(&gorm.Session{})
+		if len(rows) > 1 {
+			tx.AddError(db.Transaction(save))
+		} else {
+			tx.AddError(save(db))
+		}
+		tx.RowsAffected = rowsAffected
+		return tx
+	}
+}
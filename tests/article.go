@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"github.com/micrease/gorme"
+	"gorm.io/gorm"
+)
+
+// 文章,归档的文章默认不查询
+type ArticleModel struct {
+	gorm.Model
+	Title  string
+	Views  int
+	Status string
+}
+
+func (model ArticleModel) TableName() string {
+	return "tb_article"
+}
+
+func (model ArticleModel) GetID() any {
+	return model.ID
+}
+
+type ArticleRepo struct {
+	gorme.Repository[ArticleModel]
+}
+
+func NewArticleRepo() *ArticleRepo {
+	repo := ArticleRepo{}
+	db := GetDB()
+	repo.SetDB(db)
+	return &repo
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"github.com/micrease/gorme"
+	"gorm.io/gorm"
+)
+
+// 手机号加密存储,按盲索引列查询
+type CustomerModel struct {
+	gorm.Model
+	Name       string
+	Phone      gorme.Encrypted[string]
+	PhoneIndex string `gorm:"size:64;index" gorme:"blind_index:phone"`
+	Profile    gorme.JSON[CustomerProfile]
+	Tags       gorme.Set[string]
+	Level      gorme.Enum[CustomerLevel]
+	Flags      gorme.Flags
+}
+
+type CustomerLevel int
+
+const (
+	LevelNormal CustomerLevel = iota + 1
+	LevelVip
+)
+
+const (
+	FlagVerified gorme.Flags = 1 << iota
+	FlagBlocked
+)
+
+func init() {
+	gorme.DefineEnum(map[CustomerLevel]any{LevelNormal: "normal", LevelVip: "vip"})
+}
+
+type CustomerProfile struct {
+	City     string   `json:"city"`
+	Level    int      `json:"level"`
+	Tags     []string `json:"tags"`
+	Verified bool     `json:"verified"`
+}
+
+func (model CustomerModel) TableName() string {
+	return "tb_customer"
+}
+
+func (model CustomerModel) GetID() any {
+	return model.ID
+}
+
+type CustomerRepo struct {
+	gorme.Repository[CustomerModel]
+}
+
+func NewCustomerRepo() *CustomerRepo {
+	repo := CustomerRepo{}
+	db := GetDB()
+	repo.SetDB(db)
+	return &repo
+}
@@ -1,8 +1,13 @@
 package tests
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/micrease/gorme"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"os"
 	"testing"
 	"time"
 )
@@ -238,16 +243,17 @@ func TestInsertOrUpdate(t *testing.T) {
 	insertModel.Amount = 12
 	insertModel.UserId = 100
 	insertModel.CreatedAt = time.Now()
-	err := repo.Save(insertModel).Error
-	fmt.Println(insertModel.ID, err)
+	result := repo.Save(insertModel)
+	fmt.Println(insertModel.ID, result.LastInsertId, result.Error)
 
 	updateModel := repo.NewModel()
 	updateModel.Amount = 1222
 	updateModel.ID = 400
 	updateModel.UserId = 100
 	updateModel.CreatedAt = time.Now()
-	err = repo.Save(&updateModel).Error
-	fmt.Println(updateModel.ID, err)
+	//更新时LastInsertId为0
+	result = repo.Save(&updateModel)
+	fmt.Println(updateModel.ID, result.LastInsertId, result.Error)
 }
 
 // 插入数据Create
@@ -262,6 +268,84 @@ func TestInsert(t *testing.T) {
 	fmt.Println(model.ID, err)
 }
 
+// 写操作返回WriteResult,包含影响行数、自增主键,Debug模式下包含执行的SQL
+func TestWriteResult(t *testing.T) {
+	repo := NewOrderRepo()
+	model := repo.NewModel()
+	model.Amount = 12
+	model.UserId = 100
+	result := repo.Create(model)
+	fmt.Println(result.LastInsertId, result.RowsAffected, result.Error)
+
+	//UPDATE `tb_order` SET `amount`=13,`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND `tb_order`.`deleted_at` IS NULL
+	result = repo.NewQuery().Debug().Where("id", 1).Update("amount", 13)
+	fmt.Println(result.RowsAffected, result.SQL, result.Error)
+}
+
+// 数据库错误分类,不需要匹配MySQL的错误信息
+func TestDuplicateKey(t *testing.T) {
+	repo := NewOrderRepo()
+	model := repo.NewModel()
+	model.ID = 1
+	model.Amount = 12
+	err := repo.Create(model).Error
+	var dbErr *gorme.DBError
+	if errors.As(err, &dbErr) {
+		fmt.Println(errors.Is(err, gorme.ErrDuplicateKey), dbErr.Constraint, dbErr.Column)
+	}
+}
+
+// 分批插入,生成的ID回填到models中
+func TestCreateMany(t *testing.T) {
+	repo := NewOrderRepo()
+	var models []*OrderModel
+	for i := 0; i < 1000; i++ {
+		models = append(models, &OrderModel{UserId: 100, Amount: i})
+	}
+	result := repo.CreateMany(models, 200, gorme.WithTransaction(), gorme.WithProgress(func(done int, total int) {
+		fmt.Println(done, "/", total)
+	}))
+	fmt.Println(result.RowsAffected, result.LastInsertId, models[0].ID, result.Error)
+}
+
+// 插入或更新
+func TestUpsert(t *testing.T) {
+	repo := NewOrderRepo()
+	models := []*OrderModel{{UserId: 100, Amount: 1}, {UserId: 101, Amount: 2}}
+	// INSERT INTO `tb_order` (...) VALUES (...),(...) ON DUPLICATE KEY UPDATE `amount`=amount + VALUES(`amount`)
+	err := repo.Upsert(models, []string{"id"}, gorme.UpdateExpr(repo.NewSetter().SetExpr("amount", "amount + ?", gorme.Excluded("amount")))).Error
+	fmt.Println(err)
+
+	// INSERT INTO `tb_order` (...) VALUES (...),(...) ON DUPLICATE KEY UPDATE `amount`=VALUES(`amount`),`goods_name`=VALUES(`goods_name`)
+	err = repo.Upsert(models, []string{"id"}, gorme.UpdateColumns("amount", "goods_name")).Error
+	fmt.Println(err)
+
+	// INSERT IGNORE INTO `tb_order` (...) VALUES (...),(...)
+	err = repo.InsertIgnore(models).Error
+	fmt.Println(err)
+
+	// REPLACE INTO `tb_order` (...) VALUES (...),(...)
+	err = repo.Replace(models).Error
+	fmt.Println(err)
+}
+
+// 按主键批量更新,每行的值不同,一条语句完成
+// UPDATE `tb_order` SET `amount`=CASE `id` WHEN 1 THEN 10 WHEN 2 THEN 20 ELSE `amount` END,`updated_at`='2023-01-03 10:21:05.12' WHERE `id` IN (1,2) AND `tb_order`.`deleted_at` IS NULL
+func TestUpdateMany(t *testing.T) {
+	repo := NewOrderRepo()
+	values := map[any]gorme.Setter{
+		1: repo.NewSetter().Set("amount", 10),
+		2: repo.NewSetter().Set("amount", 20),
+	}
+	result := repo.NewQuery().UpdateMany(values, gorme.WithBatchSize(500))
+	fmt.Println(result.RowsAffected, result.Error)
+
+	models := []*OrderModel{{Amount: 11}, {Amount: 21}}
+	models[0].ID, models[1].ID = 1, 2
+	result = repo.NewQuery().BatchUpdate(models, "amount")
+	fmt.Println(result.RowsAffected, result.Error)
+}
+
 // 更新，建议用Save方法
 func TestUpdate(t *testing.T) {
 	repo := NewOrderRepo()
@@ -284,6 +368,39 @@ func TestUpdate(t *testing.T) {
 	fmt.Println(model.ID, err)
 }
 
+// 只更新修改过的列,包括0值
+// UPDATE `tb_order` SET `updated_at`='2023-01-03 10:21:05.12',`amount`=0 WHERE `tb_order`.`deleted_at` IS NULL AND `id` = 1
+func TestSaveChanges(t *testing.T) {
+	repo := NewOrderRepo()
+	repo.SetTracking(true)
+	model, err := repo.NewQuery().Where("id", 1).First()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	model.Amount = 0
+	changed, err := repo.SaveChanges(&model)
+	fmt.Println(changed, err)
+}
+
+// 按JSON Merge Patch更新,没有的字段不更新,0值照常更新
+// UPDATE `tb_order` SET `amount`=0,`goods_name`='pen',`updated_at`='2023-01-03 10:21:05.12' WHERE `tb_order`.`id` = 1 AND `tb_order`.`deleted_at` IS NULL
+func TestPatchFromJSON(t *testing.T) {
+	repo := NewOrderRepo()
+	result := repo.NewQuery().PatchFromJSON(1, []byte(`{"amount":0,"goods_name":"pen"}`), "amount", "goods_name")
+	fmt.Println(result.RowsAffected, result.Error)
+
+	//gorme: invalid patch: unknown fields foo; read-only fields ID
+	result = repo.NewQuery().PatchFromJSON(1, []byte(`{"ID":2,"foo":1}`))
+	var patchErr *gorme.PatchError
+	fmt.Println(errors.As(result.Error, &patchErr), result.Error)
+
+	//JSON列在事务中加锁读取当前值合并,只修改profile中的level
+	//SELECT * FROM `tb_customer` WHERE `tb_customer`.`id` = 1 AND `tb_customer`.`deleted_at` IS NULL LIMIT 1 FOR UPDATE
+	result = NewCustomerRepo().NewQuery().PatchFromJSON(1, []byte(`{"profile":{"level":3}}`))
+	fmt.Println(result.RowsAffected, result.Error)
+}
+
 // 根据查询条件,更新单个字段
 // UPDATE `tb_order` SET `amount`=11,`updated_at`='2022-12-30 14:43:01.11' WHERE id=1 AND `tb_order`.`deleted_at` IS NULL
 func TestUpdateSingleColumnWithQueryBuilder(t *testing.T) {
@@ -318,6 +435,42 @@ func TestUpdateBySetterWithQueryBuilder(t *testing.T) {
 	fmt.Println(err)
 }
 
+// 原子自增自减,表达式更新
+// UPDATE `tb_order` SET `amount`=`amount` - 1,`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND amount >=1  AND `tb_order`.`deleted_at` IS NULL
+func TestIncrement(t *testing.T) {
+	repo := NewOrderRepo()
+	affected, err := repo.NewQuery().Where("id", 1).Ge("amount", 1).Decrement("amount", 1)
+	fmt.Println(affected, err)
+
+	affected, err = repo.NewQuery().Where("id", 1).Increment("amount", 10)
+	fmt.Println(affected, err)
+
+	//UPDATE `tb_order` SET `amount`=`amount` + 5,`goods_name`=CONCAT(goods_name, '-x'),`user_id`=NULL,`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND `tb_order`.`deleted_at` IS NULL
+	setter := repo.NewSetter().Inc("amount", 5).SetExpr("goods_name", "CONCAT(goods_name, ?)", "-x").SetNull("user_id")
+	err = repo.NewQuery().Where("id", 1).Updates(setter).Error
+	fmt.Println(err)
+}
+
+// 状态流转,仅当状态仍为PAID时才更新为SHIPPED
+// UPDATE `tb_shipment` SET `status`='SHIPPED',`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND `tb_shipment`.`status` = 'PAID' AND `tb_shipment`.`deleted_at` IS NULL
+func TestTransition(t *testing.T) {
+	gorme.RegisterStateMachine[ShipmentModel]("status", gorme.NewStateMachine().
+		Allow("PAID", "SHIPPED", "REFUNDED").
+		Allow("SHIPPED", "DONE"))
+
+	repo := NewShipmentRepo()
+	ok, err := repo.NewQuery().Where("id", 1).Transition("status", "PAID", "SHIPPED")
+	fmt.Println(ok, err)
+
+	//gorme: transition of status from DONE to PAID is not allowed
+	ok, err = repo.NewQuery().Where("id", 1).Transition("status", "DONE", "PAID")
+	fmt.Println(ok, err)
+
+	//from与to相同时同样返回*gorme.TransitionError
+	ok, err = repo.NewQuery().Where("id", 1).Transition("status", "PAID", "PAID")
+	fmt.Println(ok, err)
+}
+
 // 根据查询条件,删除
 // DELETE FROM `tb_order` WHERE id=2
 func TestDeleteByQueryBuilder(t *testing.T) {
@@ -348,6 +501,438 @@ func TestSoftDeleteByQueryBuilder(t *testing.T) {
 	fmt.Println(err)
 }
 
+// 软删除策略,Delete为软删除,ForceDelete为物理删除
+func TestSoftDeletePolicy(t *testing.T) {
+	repo := NewOrderRepo()
+	repo.SetSoftDelete(gorme.SoftDeletePolicy{Mode: gorme.SoftDeleteTime})
+	//UPDATE `tb_order` SET `deleted_at`='2023-01-03 10:21:05.12' WHERE id=2 AND `tb_order`.`deleted_at` IS NULL
+	err := repo.NewQuery().Where("id", 2).Delete().Error
+	fmt.Println(err)
+
+	//SELECT * FROM `tb_order` WHERE `tb_order`.`deleted_at` IS NOT NULL LIMIT 10
+	rows, err := repo.NewQuery().OnlyTrashed().List(10)
+	fmt.Println(rows, err)
+
+	//SELECT * FROM `tb_order` WHERE id>0 LIMIT 10
+	rows, err = repo.NewQuery().WithTrashed().Where("id", ">", 0).List(10)
+	fmt.Println(rows, err)
+
+	//UPDATE `tb_order` SET `deleted_at`=NULL WHERE id=2 AND `tb_order`.`deleted_at` IS NOT NULL
+	err = repo.NewQuery().Where("id", 2).Restore().Error
+	fmt.Println(err)
+
+	//DELETE FROM `tb_order` WHERE `tb_order`.`id` = 2
+	err = repo.NewQuery().ForceDelete(2).Error
+	fmt.Println(err)
+}
+
+// 标记列软删除,如is_deleted 0/1
+// UPDATE `tb_order` SET `is_deleted`=1 WHERE `tb_order`.`id` IN (2,3) AND `tb_order`.`is_deleted` = 0
+func TestSoftDeleteFlag(t *testing.T) {
+	repo := NewOrderRepo()
+	repo.SetSoftDelete(gorme.SoftDeletePolicy{Mode: gorme.SoftDeleteFlag, Column: "is_deleted"})
+	err := repo.NewQuery().Delete([]int{2, 3}).Error
+	fmt.Println(err)
+}
+
+// 没有where条件的更新/删除会被拒绝
+func TestFullTableGuard(t *testing.T) {
+	repo := NewOrderRepo()
+	//gorme: refusing to delete table tb_order without where conditions, use AllowFullTable() if intended
+	err := repo.NewQuery().Where("1=1").Delete().Error
+	fmt.Println(errors.Is(err, gorm.ErrMissingWhereClause), err)
+
+	//UPDATE `tb_order` SET `amount`=0,`updated_at`='2023-01-03 10:21:05.12' WHERE `tb_order`.`deleted_at` IS NULL
+	err = repo.NewQuery().AllowFullTable().Update("amount", 0).Error
+	fmt.Println(err)
+
+	//影响行数超过100时回滚
+	repo.SetMaxAffectedRows(100)
+	err = repo.NewQuery().Where("amount", ">", 0).Update("amount", 0).Error
+	var affectedErr *gorme.AffectedRowsError
+	fmt.Println(errors.As(err, &affectedErr), err)
+}
+
+// INSERT INTO `tb_order` (`user_id`,`amount`,`goods_name`) SELECT `user_id`,`amount`,`goods_name` FROM `tb_order` WHERE id=1 AND `tb_order`.`deleted_at` IS NULL
+func TestInsertInto(t *testing.T) {
+	result := NewOrderRepo().NewQuery().Where("id=?", 1).InsertInto(OrderModel{}, "user_id", "amount", "goods_name")
+	fmt.Println(result.RowsAffected, result.Error)
+}
+
+// 联表更新与删除
+func TestJoinUpdateDelete(t *testing.T) {
+	repo := NewOrderRepo()
+	//UPDATE `tb_order` JOIN tb_user u ON u.id=tb_order.user_id SET `tb_order`.`amount`=0,`tb_order`.`updated_at`='2023-01-03 10:21:05.12' WHERE u.status=0 AND `tb_order`.`deleted_at` IS NULL
+	result := repo.NewQuery().Joins("JOIN tb_user u ON u.id=tb_order.user_id").Where("u.status=?", 0).Update("amount", 0)
+	fmt.Println(result.RowsAffected, result.Error)
+
+	//DELETE `tb_order` FROM `tb_order` JOIN tb_user u ON u.id=tb_order.user_id WHERE u.status=0
+	result = repo.NewQuery().Joins("JOIN tb_user u ON u.id=tb_order.user_id").Where("u.status=?", 0).Delete()
+	fmt.Println(result.RowsAffected, result.Error)
+}
+
+// 写入事件
+func TestEvents(t *testing.T) {
+	bus := gorme.NewEventBus()
+	gorme.Subscribe(bus, func(ctx context.Context, e gorme.Created[OrderModel]) {
+		fmt.Println("created", e.Rows[0].ID)
+	})
+	//事务提交后才调用
+	gorme.SubscribeAfterCommit(bus, func(ctx context.Context, e gorme.Updated[OrderModel]) {
+		fmt.Println("updated", e.Columns, e.Result.RowsAffected)
+	})
+
+	repo := NewOrderRepo()
+	repo.SetEventBus(bus)
+	repo.Create(&OrderModel{UserId: 10, Amount: 100, GoodsName: "book"})
+	err := repo.Transaction(func(tx *gorm.DB) error {
+		txRepo := NewOrderRepo()
+		txRepo.SetDB(tx).SetEventBus(bus)
+		return txRepo.NewQuery().Where("id", 1).Update("amount", 0).Error
+	})
+	fmt.Println(err)
+
+	//批量更新同样发布Updated事件
+	repo.NewQuery().UpdateMany(map[any]gorme.Setter{1: repo.NewSetter().Set("amount", 10)})
+}
+
+// 审计日志
+// INSERT INTO `gorme_audit_log` (`table_name`,`record_id`,`operation`,`before`,`after`,`actor`,`created_at`) VALUES ('tb_order','1','update','{"amount":100}','{"amount":0}','alice','2023-01-03 10:21:05.12')
+func TestAudit(t *testing.T) {
+	repo := NewOrderRepo()
+	repo.SetAudit(true)
+	ctx := gorme.ContextWithActor(context.Background(), "alice")
+	result := repo.NewQuery().WithContext(ctx).Where("id", 1).Update("amount", 0)
+	fmt.Println(result.Error)
+
+	//Upsert写入前后按主键和唯一索引读取冲突的行
+	// SELECT * FROM `tb_order` WHERE `tb_order`.`id` = 1
+	// INSERT INTO `tb_order` (...,`id`) VALUES (...,1) ON DUPLICATE KEY UPDATE `amount`=VALUES(`amount`)
+	result = repo.NewQuery().WithContext(ctx).Upsert(&OrderModel{Model: gorm.Model{ID: 1}, UserId: 1, Amount: 10}, nil, gorme.UpdateColumns("amount"))
+	fmt.Println(result.Error)
+
+	logs, err := repo.History(1)
+	fmt.Println(logs, err)
+}
+
+// 从context填充字段,见ShipmentModel中的CreatedBy、UpdatedBy
+// INSERT INTO `tb_shipment` (`created_at`,`updated_at`,`deleted_at`,`order_id`,`status`,`created_by`,`updated_by`) VALUES ('2023-01-03 10:21:05.12','2023-01-03 10:21:05.12',NULL,1,'PAID','alice','alice')
+// UPDATE `tb_shipment` SET `status`='SHIPPED',`updated_by`='alice',`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND `tb_shipment`.`deleted_at` IS NULL
+// UPDATE `tb_shipment` SET `updated_at`='2023-01-03 10:21:05.12',`status`='DELIVERED',`updated_by`='alice' WHERE id=1 AND `tb_shipment`.`deleted_at` IS NULL
+func TestFieldFiller(t *testing.T) {
+	repo := NewShipmentRepo()
+	repo.SetFieldFiller(gorme.ContextFiller)
+	ctx := gorme.ContextWithActor(context.Background(), "alice")
+	shipment := &ShipmentModel{OrderId: 1, Status: "PAID"}
+	result := repo.NewQuery().WithContext(ctx).Create(shipment)
+	fmt.Println(shipment.CreatedBy, shipment.UpdatedBy, result.Error)
+
+	result = repo.NewQuery().WithContext(ctx).Where("id", 1).Update("status", "SHIPPED")
+	fmt.Println(result.Error)
+
+	//按模型更新时同样填充
+	update := &ShipmentModel{Status: "DELIVERED"}
+	result = repo.NewQuery().WithContext(ctx).Where("id", 1).Updates(update)
+	fmt.Println(update.UpdatedBy, result.Error)
+}
+
+// 租户隔离,模型中声明租户列 TenantId int64 `gorme:"tenant"`
+// SELECT * FROM `tb_invoice` WHERE id=1 AND `tb_invoice`.`tenant_id` = 1 AND `tb_invoice`.`deleted_at` IS NULL ORDER BY `tb_invoice`.`id` LIMIT 1
+func TestTenant(t *testing.T) {
+	repo := NewInvoiceRepo()
+	ctx := gorme.ContextWithTenant(context.Background(), int64(1))
+	invoice, err := repo.NewQuery().WithContext(ctx).Where("id", 1).First()
+	fmt.Println(invoice, err)
+
+	//context中没有租户时返回gorme.ErrTenantRequired,IgnoreTenant()跳过租户条件
+	list, err := repo.NewQuery().Where("amount", ">", 10).List(10)
+	fmt.Println(list, err)
+	list, err = repo.NewQuery().IgnoreTenant().Where("amount", ">", 10).List(10)
+	fmt.Println(list, err)
+
+	//冲突时只更新本租户的行
+	//INSERT INTO `tb_invoice` (...,`tenant_id`,`no`,`amount`) VALUES (...,1,'A1',10) ON DUPLICATE KEY UPDATE `amount`=IF(`tenant_id` = 1, VALUES(`amount`), `amount`)
+	rows := []*InvoiceModel{{No: "A1", Amount: 10}}
+	result := repo.NewQuery().WithContext(ctx).Upsert(rows, []string{"no"}, gorme.UpdateColumns("amount"))
+	fmt.Println(result.RowsAffected, result.Error)
+
+	//gorme.ErrTenantReplace
+	result = repo.NewQuery().WithContext(ctx).Replace(rows)
+	fmt.Println(result.Error)
+
+	//SELECT * FROM `tb_invoice` WHERE `tb_invoice`.`tenant_id` = 1 AND `tb_invoice`.`no` = 'B2' AND `tb_invoice`.`deleted_at` IS NULL ORDER BY `tb_invoice`.`id` LIMIT 1
+	//INSERT INTO `tb_invoice` (`created_at`,`updated_at`,`deleted_at`,`tenant_id`,`no`,`amount`) VALUES (...,1,'B2',0)
+	var created InvoiceModel
+	err = repo.NewQuery().WithContext(ctx).FirstOrCreate(&created, InvoiceModel{No: "B2"}).DB.Error
+	fmt.Println(created.TenantId, err)
+
+	//不能把行改到其它租户,返回gorme.ErrTenantUpdate,补丁中的租户列为只读
+	result = repo.NewQuery().WithContext(ctx).Where("id", 1).Updates(&InvoiceModel{TenantId: 2})
+	fmt.Println(errors.Is(result.Error, gorme.ErrTenantUpdate))
+	result = repo.NewQuery().WithContext(ctx).PatchFromJSON(1, []byte(`{"tenant_id":2}`))
+	fmt.Println(result.Error)
+}
+
+// 命名范围与全局范围,全局范围对同一模型的所有查询生效,使用单独的模型
+// SELECT * FROM `tb_article` WHERE views>100 AND status!='archived' AND `tb_article`.`deleted_at` IS NULL LIMIT 10
+func TestScope(t *testing.T) {
+	repo := NewArticleRepo()
+	repo.DefineScope("popular", func(r *gorme.Repository[ArticleModel]) *gorme.Repository[ArticleModel] {
+		return r.Where("views", ">", 100)
+	})
+	repo.DefineGlobalScope("not_archived", func(r *gorme.Repository[ArticleModel]) *gorme.Repository[ArticleModel] {
+		return r.Where("status", "!=", "archived")
+	})
+	list, err := repo.NewQuery().Scope("popular").List(10)
+	fmt.Println(list, err)
+
+	//SELECT * FROM `tb_article` WHERE `tb_article`.`deleted_at` IS NULL LIMIT 10
+	list, err = repo.NewQuery().WithoutGlobalScope("not_archived").List(10)
+	fmt.Println(list, err)
+}
+
+// 数据权限,按context中的用户过滤
+// SELECT * FROM `tb_order` WHERE amount>0 AND user_id = 10 AND `tb_order`.`deleted_at` IS NULL LIMIT 10
+func TestDataPolicy(t *testing.T) {
+	type userKey struct{}
+	gorme.RegisterDataPolicy[OrderModel]("own", func(ctx context.Context) (clause.Expression, bool) {
+		userId, ok := ctx.Value(userKey{}).(int64)
+		if !ok {
+			return nil, false
+		}
+		return gorm.Expr("user_id = ?", userId), true
+	})
+
+	repo := NewOrderRepo()
+	repo.SetPolicyReporter(func(ctx context.Context, report gorme.PolicyReport) {
+		fmt.Println(report.Policies, report.Conditions)
+	})
+	ctx := context.WithValue(context.Background(), userKey{}, int64(10))
+	list, err := repo.NewQuery().WithContext(ctx).Where("amount", ">", 0).List(10)
+	fmt.Println(list, err)
+}
+
+// SELECT `tb_order`.`id`,`tb_order`.`created_at`,`tb_order`.`updated_at`,`tb_order`.`deleted_at`,`tb_order`.`user_id`,`tb_order`.`goods_name` FROM `tb_order` WHERE `tb_order`.`deleted_at` IS NULL LIMIT 10
+func TestFieldPolicy(t *testing.T) {
+	type roleKey struct{}
+	isAdmin := func(ctx context.Context) bool {
+		return ctx.Value(roleKey{}) == "admin"
+	}
+	repo := NewOrderRepo().SetFieldPolicy(
+		gorme.FieldPolicy{Column: "amount", Access: func(ctx context.Context) gorme.FieldAccess {
+			if isAdmin(ctx) {
+				return gorme.FieldVisible
+			}
+			return gorme.FieldHidden
+		}},
+		gorme.FieldPolicy{Column: "goods_name", Mask: gorme.Mask(1, 1), Access: func(ctx context.Context) gorme.FieldAccess {
+			if isAdmin(ctx) {
+				return gorme.FieldVisible
+			}
+			return gorme.FieldMasked
+		}},
+	)
+	list, err := repo.NewQuery().List(10)
+	fmt.Println(list, err)
+
+	//Scan到DTO时同样脱敏
+	// SELECT `id`,`goods_name` FROM `tb_order` WHERE `tb_order`.`deleted_at` IS NULL LIMIT 10
+	type orderName struct {
+		ID        uint
+		GoodsName string
+	}
+	var names []orderName
+	err = repo.NewQuery().Select("id", "goods_name").Limit(10).Scan(&names).Error
+	fmt.Println(names, err)
+
+	//表达式中引用隐藏的列返回错误
+	var total int
+	err = repo.NewQuery().Select("SUM(amount)").Scan(&total).Error
+	fmt.Println(err)
+
+	//不可写的列
+	result := repo.NewQuery().Where("id", 1).Updates(map[string]any{"amount": 100})
+	fmt.Println(result.Error)
+
+	//插入时不写入不可写的列
+	// INSERT INTO `tb_order` (`created_at`,`updated_at`,`deleted_at`,`user_id`) VALUES ('2023-01-03 10:21:05.12','2023-01-03 10:21:05.12',NULL,1)
+	result = repo.NewQuery().Create(&OrderModel{UserId: 1, Amount: 100, GoodsName: "pen"})
+	fmt.Println(result.Error)
+
+	//管理员看到的值与没有列权限时一致
+	ctx := context.WithValue(context.Background(), roleKey{}, "admin")
+	list, err = repo.NewQuery().WithContext(ctx).List(10)
+	fmt.Println(list, err)
+	raw, _ := NewOrderRepo().NewQuery().List(10)
+	for i := range list {
+		if i < len(raw) && (list[i].Amount != raw[i].Amount || list[i].GoodsName != raw[i].GoodsName) {
+			t.Errorf("admin got masked order %d: %v", list[i].ID, list[i])
+		}
+	}
+}
+
+// INSERT INTO `tb_customer` (`created_at`,`updated_at`,`deleted_at`,`name`,`phone`,`phone_index`) VALUES ('2023-01-03 10:21:05.12','2023-01-03 10:21:05.12',NULL,'tom','k1:3qsSv5Xcy+rcZq6v...','ade33aca34f3...')
+// SELECT * FROM `tb_customer` WHERE phone_index='ade33aca34f3...' AND `tb_customer`.`deleted_at` IS NULL ORDER BY `tb_customer`.`id` LIMIT 1
+func TestEncrypted(t *testing.T) {
+	keyring := &gorme.Keyring{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")},
+		Index:   []byte("blind-index-key"),
+	}
+	gorme.SetKeyProvider(keyring)
+
+	repo := NewCustomerRepo()
+	result := repo.NewQuery().Create(&CustomerModel{Name: "tom", Phone: gorme.NewEncrypted("13812341234")})
+	fmt.Println(result.Error)
+	customer, err := repo.NewQuery().Where("phone", "13812341234").First()
+	fmt.Println(customer.Phone.Data, err)
+	//Eq同样按盲索引查询
+	//SELECT * FROM `tb_customer` WHERE phone_index ='<hmac>' AND `tb_customer`.`deleted_at` IS NULL ORDER BY `tb_customer`.`id` LIMIT 1
+	customer, err = repo.NewQuery().Eq("phone", "13812341234").First()
+	fmt.Println(customer.Phone.Data, err)
+	//盲索引不支持LIKE,返回错误
+	_, err = repo.NewQuery().Where("phone", "like", "138%").First()
+	fmt.Println(err)
+	//按模型更新加密列时一起更新盲索引
+	//UPDATE `tb_customer` SET `updated_at`='2023-01-03 10:21:05.12',`phone`='k1:...',`phone_index`='<hmac>' WHERE id=1 AND `tb_customer`.`deleted_at` IS NULL
+	result = repo.NewQuery().Where("id", customer.ID).Updates(&CustomerModel{Phone: gorme.NewEncrypted("13900000000")})
+	fmt.Println(result.RowsAffected, result.Error)
+
+	//轮换密钥,旧密钥保留到重新加密完成
+	keyring.Keys["k2"] = []byte("abcdef0123456789abcdef0123456789")
+	keyring.Current = "k2"
+	result = repo.NewQuery().RotateKeys(100)
+	fmt.Println(result.RowsAffected, result.Error)
+}
+
+// SELECT * FROM `tb_customer` WHERE JSON_UNQUOTE(JSON_EXTRACT(`profile`, '$.city')) = 'beijing' AND JSON_EXTRACT(`profile`, '$.verified') = CAST('true' AS JSON) AND JSON_CONTAINS(`profile`, '["vip"]', '$.tags') AND JSON_LENGTH(`profile`, '$.tags') > 1 AND `tb_customer`.`deleted_at` IS NULL ORDER BY JSON_EXTRACT(`profile`, '$.level') DESC LIMIT 10
+func TestJSON(t *testing.T) {
+	list, err := NewCustomerRepo().NewQuery().
+		WhereJSON("profile", "city", "=", "beijing").
+		WhereJSON("profile", "verified", "=", true).
+		JSONContains("profile", []string{"vip"}, "tags").
+		JSONLength("profile", "tags", ">", 1).
+		OrderByJSON("profile", "level", true).
+		List(10)
+	for _, customer := range list {
+		fmt.Println(customer.Profile.Data.City, customer.Profile.Data.Tags)
+	}
+	fmt.Println(err)
+
+	//与Order()按调用顺序排序
+	// SELECT * FROM `tb_customer` WHERE `tb_customer`.`deleted_at` IS NULL ORDER BY JSON_EXTRACT(`profile`, '$.level') DESC,id LIMIT 10
+	list, err = NewCustomerRepo().NewQuery().OrderByJSON("profile", "level", true).Order("id").List(10)
+	fmt.Println(len(list), err)
+}
+
+// SELECT * FROM `tb_customer` WHERE (FIND_IN_SET('new', `tags`) OR FIND_IN_SET('hot', `tags`)) AND `flags` & 1 = 1 AND `flags` & 2 = 0 AND level='vip' AND `tb_customer`.`deleted_at` IS NULL LIMIT 10
+func TestSetEnumFlags(t *testing.T) {
+	tags := gorme.NewSet("new")
+	tags.Add("hot")
+	customer := &CustomerModel{Name: "tom", Tags: tags, Level: gorme.NewEnum(LevelVip), Flags: FlagVerified}
+	result := NewCustomerRepo().NewQuery().Create(customer)
+	fmt.Println(result.Error)
+
+	//未定义的枚举值写入时返回*gorme.EnumError
+	result = NewCustomerRepo().NewQuery().Create(&CustomerModel{Level: gorme.NewEnum(CustomerLevel(9))})
+	var enumErr *gorme.EnumError
+	fmt.Println(errors.As(result.Error, &enumErr))
+	//按模型或map更新时同样在执行前检查
+	result = NewCustomerRepo().NewQuery().Where("id", customer.ID).Updates(&CustomerModel{Level: gorme.NewEnum(CustomerLevel(9))})
+	fmt.Println(errors.As(result.Error, &enumErr))
+	result = NewCustomerRepo().NewQuery().Where("id", customer.ID).Update("level", gorme.NewEnum(CustomerLevel(9)))
+	fmt.Println(errors.As(result.Error, &enumErr))
+
+	list, err := NewCustomerRepo().NewQuery().
+		HasAny("tags", "new", "hot").
+		HasFlag("flags", FlagVerified).
+		LacksFlag("flags", FlagBlocked).
+		Where("level", gorme.NewEnum(LevelVip)).
+		List(10)
+	fmt.Println(list, err)
+}
+
+// SELECT * FROM `tb_order` WHERE `tb_order`.`created_at` >= '2023-01-01 00:00:00' AND `tb_order`.`created_at` < '2023-01-08 00:00:00' AND `updated_at` >= '2023-01-01 00:00:00' AND `updated_at` < '2023-02-01 00:00:00' AND `tb_order`.`deleted_at` IS NULL LIMIT 10
+func TestDate(t *testing.T) {
+	list, err := NewOrderRepo().NewQuery().LastNDays(7).WhereMonth("updated_at", 2023, time.January).List(10)
+	fmt.Println(list, err)
+
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	list, err = NewOrderRepo().NewQuery().TodayIn(loc).List(10)
+	fmt.Println(list, err)
+
+	//9点以后更新的
+	// SELECT * FROM `tb_order` WHERE `tb_order`.`created_at` >= '2023-01-01 00:00:00' AND `tb_order`.`created_at` < '2023-01-08 00:00:00' AND TIME(`updated_at`) >= '09:00:00' AND `tb_order`.`deleted_at` IS NULL LIMIT 10
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, loc)
+	list, err = NewOrderRepo().NewQuery().CreatedBetween(from, from.AddDate(0, 0, 7)).WhereTime("updated_at", ">=", from.Add(9*time.Hour)).List(10)
+	fmt.Println(list, err)
+
+	//n小于1时返回错误
+	list, err = NewOrderRepo().NewQuery().LastNDays(0).List(10)
+	fmt.Println(list, err)
+}
+
+// DSN中loc为UTC时按偏移差转换,loc与分段时区相同时不转换
+// SELECT DATE_FORMAT(DATE_ADD(`created_at`, INTERVAL 28800 SECOND), '%Y-%m-%d 00:00:00') AS gorme_bucket, COUNT(*) AS count, SUM(amount) AS amount, AVG(amount) AS avg_amount FROM `tb_order` WHERE `created_at` >= '2023-01-01 00:00:00' AND `created_at` < '2023-01-08 00:00:00' AND `tb_order`.`deleted_at` IS NULL GROUP BY `gorme_bucket` ORDER BY gorme_bucket
+func TestTimeBuckets(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, loc)
+	repo := NewOrderRepo()
+	buckets, err := gorme.TimeBuckets[OrderStats](repo.NewQuery().
+		Select("COUNT(*) AS count, SUM(amount) AS amount, AVG(amount) AS avg_amount").
+		GroupByTime("created_at", gorme.BucketDay, loc), from, from.AddDate(0, 0, 7))
+	//没有数据的日期也会返回,值为0
+	for _, bucket := range buckets {
+		fmt.Println(bucket.Start.Format("2006-01-02"), bucket.Value.Count, bucket.Value.Amount, bucket.Value.AvgAmount)
+	}
+	fmt.Println(err)
+
+	//只有一个聚合值时,别名为value或不写Select按COUNT(*)统计
+	counts, err := gorme.TimeBuckets[int64](repo.NewQuery().GroupByTime("created_at", gorme.BucketHour, loc), from, from.AddDate(0, 0, 1))
+	fmt.Println(counts, err)
+
+	//Select带参数
+	// SELECT DATE_FORMAT(DATE_ADD(`created_at`, INTERVAL 28800 SECOND), '%Y-%m-%d 00:00:00') AS gorme_bucket, SUM(CASE WHEN amount > 100 THEN 1 ELSE 0 END) AS value FROM `tb_order` WHERE ... GROUP BY `gorme_bucket` ORDER BY gorme_bucket
+	counts, err = gorme.TimeBuckets[int64](repo.NewQuery().
+		Select("SUM(CASE WHEN amount > ? THEN 1 ELSE 0 END) AS value", 100).
+		GroupByTime("created_at", gorme.BucketDay, loc), from, from.AddDate(0, 0, 7))
+	fmt.Println(counts, err)
+}
+
+// SELECT count(*) FROM (SELECT DATE_FORMAT(DATE_ADD(`created_at`, INTERVAL 28800 SECOND), '%Y-%m-%d 00:00:00') AS `r_day`, u.user_name AS `r_user`, COUNT(*) AS `r_orders`, SUM(amount) AS `r_amount` FROM `tb_order` LEFT JOIN tb_user u ON u.id = tb_order.user_id WHERE amount>0 AND `tb_order`.`deleted_at` IS NULL GROUP BY `r_day`,`r_user` HAVING COUNT(*) > 1) AS report
+// SELECT ... ORDER BY `r_day`,`r_amount` DESC LIMIT 10
+func TestReport(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	orders := gorme.Count("orders")
+	amount := gorme.Sum("amount", "amount")
+	report := gorme.NewReport[OrderModel]().
+		Dimension(
+			gorme.Dimension{Name: "day", Column: "created_at", Bucket: gorme.BucketDay, Location: loc},
+			gorme.Dimension{Name: "user", Column: "u.user_name", Join: "LEFT JOIN tb_user u ON u.id = tb_order.user_id"},
+		).
+		Metric(orders, amount, gorme.CountDistinct("goods", "goods_name"), gorme.Ratio("avg_amount", amount, orders))
+
+	table, err := report.Run(NewOrderRepo().NewQuery().Where("amount", ">", 0), gorme.ReportRequest{
+		Dimensions: []string{"day", "user"},
+		Metrics:    []string{"orders", "amount", "avg_amount"},
+		Having:     []gorme.MetricFilter{{Metric: "orders", Op: ">", Value: 1}},
+		Sort:       []string{"day", "-amount"},
+		PageNo:     1,
+		PageSize:   10,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(table.Total)
+	table.CSV(os.Stdout)
+
+	//每天一行,每个用户一列
+	pivot, err := table.Pivot("day", "user", "amount")
+	fmt.Println(err)
+	pivot.CSV(os.Stdout)
+}
+
 func TestLeftJoin(t *testing.T) {
 	list, err := NewOrderSummaryRepo().NewQuery().Select("u.id as user_id,u.user_name as username").Where("u.id=?", 10).List(10)
 	fmt.Println(list, err)
@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"github.com/micrease/gorme"
+	"gorm.io/gorm"
+)
+
+// 发票,按租户隔离
+type InvoiceModel struct {
+	gorm.Model
+	TenantId int64  `gorme:"tenant"`
+	No       string `gorm:"size:32;uniqueIndex"`
+	Amount   int
+}
+
+func (model InvoiceModel) TableName() string {
+	return "tb_invoice"
+}
+
+func (model InvoiceModel) GetID() any {
+	return model.ID
+}
+
+type InvoiceRepo struct {
+	gorme.Repository[InvoiceModel]
+}
+
+func NewInvoiceRepo() *InvoiceRepo {
+	repo := InvoiceRepo{}
+	db := GetDB()
+	repo.SetDB(db)
+	return &repo
+}
@@ -36,3 +36,10 @@ func NewOrderRepo() *OrderRepo {
 	repo.SetDB(db)
 	return &repo
 }
+
+// 按时间分段的订单统计
+type OrderStats struct {
+	Count     int64
+	Amount    float64
+	AvgAmount float64
+}
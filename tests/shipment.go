@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"github.com/micrease/gorme"
+	"gorm.io/gorm"
+)
+
+// 发货单,状态按状态机流转,操作人自动填充
+type ShipmentModel struct {
+	gorm.Model
+	OrderId   int64
+	Status    string
+	CreatedBy string `gorme:"fill:actor,create"`
+	UpdatedBy string `gorme:"fill:actor"`
+}
+
+func (model ShipmentModel) TableName() string {
+	return "tb_shipment"
+}
+
+func (model ShipmentModel) GetID() any {
+	return model.ID
+}
+
+type ShipmentRepo struct {
+	gorme.Repository[ShipmentModel]
+}
+
+func NewShipmentRepo() *ShipmentRepo {
+	repo := ShipmentRepo{}
+	db := GetDB()
+	repo.SetDB(db)
+	return &repo
+}
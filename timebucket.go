@@ -0,0 +1,375 @@
+package gorme
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type TimeBucket string
+
+const (
+	BucketMinute TimeBucket = "minute"
+	BucketHour   TimeBucket = "hour"
+	BucketDay    TimeBucket = "day"
+	BucketWeek   TimeBucket = "week" //从周一开始
+	BucketMonth  TimeBucket = "month"
+	BucketYear   TimeBucket = "year"
+)
+
+// 一个时间段的聚合结果
+type Bucket[V any] struct {
+	Start time.Time
+	Value V
+}
+
+type timeGroup struct {
+	column string
+	bucket TimeBucket
+	loc    *time.Location
+}
+
+// 分段的开始时间
+func (b TimeBucket) truncate(t time.Time) time.Time {
+	switch b {
+	case BucketMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case BucketHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case BucketWeek:
+		day := startOfDay(t)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case BucketYear:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return startOfDay(t)
+}
+
+// 下一个分段的开始时间
+func (b TimeBucket) next(t time.Time) time.Time {
+	switch b {
+	case BucketMinute:
+		return t.Add(time.Minute)
+	case BucketHour:
+		return t.Add(time.Hour)
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	case BucketYear:
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// 按时间分段分组,tz为分段所在的时区,为nil时为本地时区
+// MySQL的DATETIME列按驱动DSN中的loc读写,分段时从loc转换到tz
+// 聚合列用Select指定,结果用TimeBuckets读取
+//
+//	repo.NewQuery().Select("COUNT(*) AS count, SUM(amount) AS amount").GroupByTime("created_at", gorme.BucketDay, loc)
+func (r *Repository[T]) GroupByTime(column string, bucket TimeBucket, tz *time.Location) *Repository[T] {
+	if tz == nil {
+		tz = time.Local
+	}
+	switch bucket {
+	case BucketMinute, BucketHour, BucketDay, BucketWeek, BucketMonth, BucketYear:
+		r.state.timeGroup = &timeGroup{column: column, bucket: bucket, loc: tz}
+	default:
+		r.DB = r.DB.Session(&gorm.Session{})
+		r.DB.AddError(fmt.Errorf("gorme: unsupported time bucket %s", bucket))
+	}
+	return r
+}
+
+// 分段开始时间的SQL,结果为group.loc的"2006-01-02 15:04:05"
+// MySQL、SQLite按存储时区和group.loc的偏移差转换,[from, to)内偏移有变化(如夏令时)时按变化的时刻分段
+// PostgreSQL按时区名转换
+func (r *Repository[T]) bucketExpr(group *timeGroup, from, to time.Time) clause.Expr {
+	column := clause.Column{Name: group.column}
+
+	switch r.DB.Dialector.Name() {
+	case "postgres":
+		zone := clause.Expr{SQL: "?", Vars: []any{group.loc.String()}}
+		if name := group.loc.String(); name == "Local" || name == "" {
+			//POSIX格式的偏移符号相反,改用INTERVAL
+			at := from
+			if at.IsZero() {
+				at = time.Now()
+			}
+			_, offset := at.In(group.loc).Zone()
+			sign := "+"
+			if offset < 0 {
+				sign, offset = "-", -offset
+			}
+			zone = clause.Expr{SQL: "INTERVAL ?", Vars: []any{fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)}}
+		}
+		unit := string(group.bucket)
+		return clause.Expr{SQL: "to_char(date_trunc(?, ? AT TIME ZONE ?), 'YYYY-MM-DD HH24:MI:SS')", Vars: []any{unit, column, zone}}
+	case "sqlite":
+		local := r.localTime(column, group.loc, from, to)
+		formats := map[TimeBucket]string{
+			BucketMinute: "%Y-%m-%d %H:%M:00",
+			BucketHour:   "%Y-%m-%d %H:00:00",
+			BucketDay:    "%Y-%m-%d 00:00:00",
+			BucketMonth:  "%Y-%m-01 00:00:00",
+			BucketYear:   "%Y-01-01 00:00:00",
+		}
+		if group.bucket == BucketWeek {
+			return clause.Expr{SQL: "strftime('%Y-%m-%d 00:00:00', ?, 'weekday 0', '-6 days')", Vars: []any{local}}
+		}
+		return clause.Expr{SQL: "strftime(?, ?)", Vars: []any{formats[group.bucket], local}}
+	}
+
+	local := r.localTime(column, group.loc, from, to)
+	formats := map[TimeBucket]string{
+		BucketMinute: "%Y-%m-%d %H:%i:00",
+		BucketHour:   "%Y-%m-%d %H:00:00",
+		BucketDay:    "%Y-%m-%d 00:00:00",
+		BucketMonth:  "%Y-%m-01 00:00:00",
+		BucketYear:   "%Y-01-01 00:00:00",
+	}
+	if group.bucket == BucketWeek {
+		return clause.Expr{SQL: "DATE_FORMAT(DATE_SUB(?, INTERVAL WEEKDAY(?) DAY), '%Y-%m-%d 00:00:00')", Vars: []any{local, local}}
+	}
+	return clause.Expr{SQL: "DATE_FORMAT(?, ?)", Vars: []any{local, formats[group.bucket]}}
+}
+
+// 时间列存储时使用的时区,MySQL为驱动DSN中的loc(默认UTC),SQLite为UTC
+func (r *Repository[T]) storedLocation() *time.Location {
+	if dialector, ok := r.DB.Dialector.(*mysql.Dialector); ok {
+		config := dialector.DSNConfig
+		if config == nil && len(dialector.DSN) > 0 {
+			config, _ = gomysql.ParseDSN(dialector.DSN)
+		}
+		if config != nil && config.Loc != nil {
+			return config.Loc
+		}
+	}
+	return time.UTC
+}
+
+// 存储时区的时间转为loc的时间,[from, to)内偏移有变化时按变化的时刻分段
+// CASE WHEN `created_at` < '2024-03-31 01:00:00' THEN DATE_ADD(`created_at`, INTERVAL 3600 SECOND) ELSE DATE_ADD(`created_at`, INTERVAL 7200 SECOND) END
+func (r *Repository[T]) localTime(column clause.Column, loc *time.Location, from, to time.Time) clause.Expr {
+	stored := r.storedLocation()
+	if sameZone(stored, loc) {
+		return clause.Expr{SQL: "?", Vars: []any{column}}
+	}
+	if from.IsZero() {
+		from = time.Now()
+	}
+	if to.Before(from) {
+		to = from
+	}
+	shift := func(at time.Time) clause.Expr {
+		_, storedOffset := at.In(stored).Zone()
+		_, offset := at.In(loc).Zone()
+		seconds := offset - storedOffset
+		if seconds == 0 {
+			return clause.Expr{SQL: "?", Vars: []any{column}}
+		}
+		if r.DB.Dialector.Name() == "sqlite" {
+			return clause.Expr{SQL: "datetime(?, ?)", Vars: []any{column, fmt.Sprintf("%+d seconds", seconds)}}
+		}
+		return clause.Expr{SQL: "DATE_ADD(?, INTERVAL ? SECOND)", Vars: []any{column, seconds}}
+	}
+
+	boundaries := append(zoneTransitions(stored, from, to), zoneTransitions(loc, from, to)...)
+	if len(boundaries) == 0 {
+		return shift(from)
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+	sql := "CASE"
+	var vars []any
+	start := from
+	for _, boundary := range boundaries {
+		if !boundary.After(start) {
+			continue
+		}
+		sql += " WHEN ? < ? THEN ?"
+		vars = append(vars, column, boundary.In(stored).Format("2006-01-02 15:04:05"), shift(start))
+		start = boundary
+	}
+	sql += " ELSE ? END"
+	vars = append(vars, shift(start))
+	return clause.Expr{SQL: sql, Vars: vars}
+}
+
+func sameZone(a, b *time.Location) bool {
+	return a == b || a.String() == b.String()
+}
+
+// 时区偏移是否会变化,按当年1月和7月的偏移判断
+func zoneChanges(loc *time.Location) bool {
+	year := time.Now().Year()
+	_, winter := time.Date(year, time.January, 1, 0, 0, 0, 0, loc).Zone()
+	_, summer := time.Date(year, time.July, 1, 0, 0, 0, 0, loc).Zone()
+	return winter != summer
+}
+
+// [from, to)内loc的偏移发生变化的时刻,按天查找后二分到秒
+func zoneTransitions(loc *time.Location, from, to time.Time) []time.Time {
+	var transitions []time.Time
+	_, offset := from.In(loc).Zone()
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if next.After(to) {
+			next = to
+		}
+		if _, o := next.In(loc).Zone(); o != offset {
+			lo, hi := t.Unix(), next.Unix()
+			for hi-lo > 1 {
+				mid := (lo + hi) / 2
+				if _, o := time.Unix(mid, 0).In(loc).Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			transitions = append(transitions, time.Unix(hi, 0))
+			offset = o
+		}
+		t = next
+	}
+	return transitions
+}
+
+// 分段转换时区的范围,时区偏移会变化且from、to为0时按当前条件下数据的范围
+func (r *Repository[T]) bucketRange(group *timeGroup, from, to time.Time) (time.Time, time.Time, error) {
+	stored := r.storedLocation()
+	if (!from.IsZero() && !to.IsZero()) || sameZone(stored, group.loc) || (!zoneChanges(stored) && !zoneChanges(group.loc)) {
+		return from, to, nil
+	}
+	var values [2]any
+	col := clause.Column{Name: group.column}
+	rows, err := r.DB.Session(&gorm.Session{}).Select("MIN(?), MAX(?)", col, col).Rows()
+	if err != nil || rows == nil {
+		return from, to, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err = rows.Scan(&values[0], &values[1]); err != nil {
+			return from, to, err
+		}
+	}
+	var times [2]time.Time
+	for i, value := range values {
+		switch v := value.(type) {
+		case time.Time:
+			times[i] = v
+		case []byte, string:
+			text := fmt.Sprintf("%s", v)
+			if len(text) > 19 {
+				text = text[:19]
+			}
+			times[i], _ = time.ParseInLocation("2006-01-02 15:04:05", text, stored)
+		}
+	}
+	if from.IsZero() {
+		from = times[0]
+	}
+	if to.IsZero() && !times[1].IsZero() {
+		to = times[1].Add(time.Second)
+	}
+	return from, to, nil
+}
+
+// 读取GroupByTime的分组结果,按时间排序,没有数据的分段以V的0值补齐
+// V为结构体时按列名读取Select中的各个聚合列,否则读取别名为value的列,没有Select时为COUNT(*)
+// from、to不为0时只统计[from, to)之间的数据并补齐这个范围内的分段,from为0且没有数据时返回空
+func TimeBuckets[V any, T Model](r *Repository[T], from, to time.Time) ([]Bucket[V], error) {
+	group := r.state.timeGroup
+	if group == nil {
+		r.Reset()
+		return nil, errors.New("gorme: TimeBuckets requires GroupByTime")
+	}
+	if !from.IsZero() {
+		r.DB = r.DB.Where(clause.Gte{Column: clause.Column{Name: group.column}, Value: from})
+	}
+	if !to.IsZero() {
+		r.DB = r.DB.Where(clause.Lt{Column: clause.Column{Name: group.column}, Value: to})
+	}
+
+	var v V
+	valueType := reflect.TypeOf(v)
+	embedded := valueType.Kind() == reflect.Struct && valueType != reflect.TypeOf(time.Time{}) &&
+		!reflect.PtrTo(valueType).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+	//分段列和V一起读取
+	valueTag := `gorm:"column:value"`
+	if embedded {
+		valueTag = `gorm:"embedded"`
+	}
+	rowType := reflect.StructOf([]reflect.StructField{
+		{Name: "Bucket", Type: reflect.TypeOf(""), Tag: `gorm:"column:gorme_bucket"`},
+		{Name: "Value", Type: valueType, Tag: reflect.StructTag(valueTag)},
+	})
+	rows := reflect.New(reflect.SliceOf(rowType))
+
+	r.prepare()
+	//Select带参数时gorm把SQL和参数放在SELECT子句中
+	selects := strings.Join(r.DB.Statement.Selects, ", ")
+	var vars []any
+	if c, ok := r.DB.Statement.Clauses["SELECT"]; ok {
+		if expr, ok := c.Expression.(clause.Expr); ok {
+			selects, vars = expr.SQL, expr.Vars
+		}
+	}
+	if len(selects) == 0 {
+		selects = "COUNT(*) AS value"
+	}
+	rangeFrom, rangeTo, err := r.bucketRange(group, from, to)
+	if err != nil {
+		r.Reset()
+		return nil, r.translateError(err)
+	}
+	expr := r.bucketExpr(group, rangeFrom, rangeTo)
+	err = r.DB.Select("? AS gorme_bucket, "+selects, append([]any{expr}, vars...)...).
+		Group("gorme_bucket").
+		Order("gorme_bucket").
+		Scan(rows.Interface()).Error
+	r.Reset()
+	if err != nil {
+		return nil, r.translateError(err)
+	}
+
+	//按Unix时间取值,同一时刻的time.Time可能有不同的时区和单调时钟
+	values := map[int64]V{}
+	var starts []time.Time
+	for i := 0; i < rows.Elem().Len(); i++ {
+		row := rows.Elem().Index(i)
+		start, err := time.ParseInLocation("2006-01-02 15:04:05", row.Field(0).String(), group.loc)
+		if err != nil {
+			return nil, fmt.Errorf("gorme: invalid time bucket %q: %w", row.Field(0).String(), err)
+		}
+		values[start.Unix()] = row.Field(1).Interface().(V)
+		starts = append(starts, start)
+	}
+
+	//补齐没有数据的分段,没有数据也没有from时返回空
+	if from.IsZero() {
+		if len(starts) == 0 {
+			return nil, nil
+		}
+		from = starts[0]
+	}
+	if to.IsZero() && len(starts) > 0 {
+		to = group.bucket.next(starts[len(starts)-1])
+	}
+	var buckets []Bucket[V]
+	for start := group.bucket.truncate(from.In(group.loc)); start.Before(to); start = group.bucket.next(start) {
+		buckets = append(buckets, Bucket[V]{Start: start, Value: values[start.Unix()]})
+	}
+	return buckets, nil
+}
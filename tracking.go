@@ -0,0 +1,207 @@
+package gorme
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+// 最多记录原始值的行数,超过时丢弃最早记录的行
+const maxTrackedRows = 10000
+
+// 按主键记录的原始值
+type tracker struct {
+	sync.Mutex
+	values map[string]map[string]any
+	keys   []string
+}
+
+func newTracker() *tracker {
+	return &tracker{values: map[string]map[string]any{}}
+}
+
+func (t *tracker) load(key string) (map[string]any, bool) {
+	t.Lock()
+	defer t.Unlock()
+	values, ok := t.values[key]
+	return values, ok
+}
+
+func (t *tracker) store(key string, values map[string]any) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = values
+	for len(t.keys) > maxTrackedRows {
+		delete(t.values, t.keys[0])
+		t.keys = t.keys[1:]
+	}
+}
+
+// 开启后,通过First/Last/Take/List/Paginate加载的数据会按主键记录原始值,SaveChanges只更新修改过的列
+// 最多记录maxTrackedRows行,被丢弃的行在SaveChanges时重新查询原始值
+func (r *Repository[T]) SetTracking(enabled bool) *Repository[T] {
+	if !enabled {
+		r.tracker = nil
+	} else if r.tracker == nil {
+		r.tracker = newTracker()
+	}
+	return r
+}
+
+// 清除记录的原始值
+func (r *Repository[T]) ClearTracking() *Repository[T] {
+	if r.tracker != nil {
+		r.tracker = newTracker()
+	}
+	return r
+}
+
+// 记录加载的数据的原始值
+func (r *Repository[T]) track(values ...*T) {
+	if r.tracker == nil {
+		return
+	}
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		return
+	}
+	for _, value := range values {
+		rv := reflect.ValueOf(value).Elem()
+		pk, isZero := s.PrioritizedPrimaryField.ValueOf(context.Background(), rv)
+		if isZero {
+			continue
+		}
+		r.tracker.store(fmt.Sprint(pk), snapshot(s, rv))
+	}
+}
+
+// 只更新value中与原始值不同的列,包括零值,返回修改过的列名
+// 没有记录原始值时按主键和当前查询的条件(租户、数据权限、软删除等)查出当前数据比较
+// UPDATE `tb_order` SET `amount`=0,`updated_at`='2023-01-03 10:21:05.12' WHERE `tb_order`.`deleted_at` IS NULL AND `id` = 1
+func (r *Repository[T]) SaveChanges(value *T) ([]string, error) {
+	s, err := r.schemaWithPrimaryKey()
+	if err != nil {
+		r.Reset()
+		return nil, err
+	}
+	rv := reflect.ValueOf(value).Elem()
+	pk, isZero := s.PrioritizedPrimaryField.ValueOf(context.Background(), rv)
+	if isZero {
+		r.Reset()
+		return nil, fmt.Errorf("gorme: SaveChanges requires a primary key")
+	}
+
+	var original map[string]any
+	if r.tracker != nil {
+		original, _ = r.tracker.load(fmt.Sprint(pk))
+	}
+	if original == nil {
+		var t T
+		r.prepare()
+		err = r.DB.Session(&gorm.Session{}).Take(&t, pk).Error
+		if err != nil {
+			r.Reset()
+			return nil, r.translateError(err)
+		}
+		original = snapshot(s, reflect.ValueOf(&t).Elem())
+	}
+
+	if err := r.checkTenantUpdate(value); err != nil {
+		r.Reset()
+		return nil, err
+	}
+	r.fillRows([]*T{value}, fillUpdate)
+	current := snapshot(s, rv)
+	tenantColumn, _, scoped, _ := r.tenant()
+	var changed []string
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 || field.PrimaryKey || field.AutoUpdateTime > 0 {
+			continue
+		}
+		//租户列不更新
+		if scoped && field.DBName == tenantColumn {
+			continue
+		}
+		if !reflect.DeepEqual(original[field.DBName], current[field.DBName]) {
+			changed = append(changed, field.DBName)
+		}
+	}
+	if len(changed) == 0 {
+		r.Reset()
+		return nil, nil
+	}
+	if err := r.checkColumnsWritable(changed...); err != nil {
+		r.Reset()
+		return nil, err
+	}
+	columns := append([]string{}, changed...)
+	for _, field := range s.Fields {
+		if field.AutoUpdateTime > 0 && len(field.DBName) > 0 {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	result := r.guardedWrite("update", value, func(db *gorm.DB) *gorm.DB {
+		return db.Model(value).Select(columns).Updates(value)
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	r.publish(result, Updated[T]{Rows: []*T{value}, Columns: changed, Result: result})
+	if r.tracker != nil {
+		r.tracker.store(fmt.Sprint(pk), snapshot(s, rv))
+	}
+	return changed, nil
+}
+
+// 按列名记录模型的值,值实现了driver.Valuer时记录数据库中的值,指针记录指向的值
+func snapshot(s *schema.Schema, rv reflect.Value) map[string]any {
+	values := make(map[string]any, len(s.Fields))
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), rv)
+		values[field.DBName] = snapshotValue(value)
+	}
+	return values
+}
+
+func snapshotValue(value any) any {
+	//加密列用盲索引比较,不保留明文
+	if e, ok := value.(encryptedValue); ok {
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		if digest, err := BlindIndex(e); err == nil {
+			return digest
+		}
+	}
+	if valuer, ok := value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(valuer); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		v, err := valuer.Value()
+		if err == nil {
+			value = v
+		}
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		return snapshotValue(rv.Elem().Interface())
+	}
+	if b, ok := value.([]byte); ok {
+		return append([]byte(nil), b...)
+	}
+	return value
+}
@@ -0,0 +1,104 @@
+package gorme
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sync"
+)
+
+// 状态机,声明允许的状态流转
+type StateMachine struct {
+	transitions map[string]map[string]bool
+}
+
+func NewStateMachine() *StateMachine {
+	return &StateMachine{transitions: map[string]map[string]bool{}}
+}
+
+// 允许从from流转到to
+func (m *StateMachine) Allow(from any, to ...any) *StateMachine {
+	key := fmt.Sprint(from)
+	if m.transitions[key] == nil {
+		m.transitions[key] = map[string]bool{}
+	}
+	for _, v := range to {
+		m.transitions[key][fmt.Sprint(v)] = true
+	}
+	return m
+}
+
+func (m *StateMachine) CanTransition(from, to any) bool {
+	return m.transitions[fmt.Sprint(from)][fmt.Sprint(to)]
+}
+
+type stateMachineKey struct {
+	model  reflect.Type
+	column string
+}
+
+var stateMachines sync.Map
+
+// 为模型T的状态列注册状态机,Transition时校验流转是否合法
+func RegisterStateMachine[T Model](column string, machine *StateMachine) {
+	var t T
+	stateMachines.Store(stateMachineKey{model: reflect.TypeOf(t), column: column}, machine)
+}
+
+func lookupStateMachine[T Model](column string) (*StateMachine, bool) {
+	var t T
+	machine, ok := stateMachines.Load(stateMachineKey{model: reflect.TypeOf(t), column: column})
+	if !ok {
+		return nil, false
+	}
+	return machine.(*StateMachine), true
+}
+
+// 状态机不允许的流转
+type TransitionError struct {
+	Column string
+	From   any
+	To     any
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("gorme: transition of %s from %v to %v is not allowed", e.Column, e.From, e.To)
+}
+
+// 状态流转,仅当column当前值仍为from时更新为to,返回是否更新成功
+// extra为同时更新的其它字段,加密列同样加密并更新盲索引
+// from与to相同时返回*TransitionError:MySQL的影响行数不含值未改变的行,相同时无法判断是否更新成功
+// UPDATE `tb_order` SET `status`='SHIPPED',`updated_at`='2023-01-03 10:21:05.12' WHERE id=1 AND `tb_order`.`status` = 'PAID'
+func (r *Repository[T]) Transition(column string, from, to any, extra ...Setter) (bool, error) {
+	if machine, ok := lookupStateMachine[T](column); fmt.Sprint(from) == fmt.Sprint(to) || ok && !machine.CanTransition(from, to) {
+		r.Reset()
+		return false, &TransitionError{Column: column, From: from, To: to}
+	}
+
+	setter := r.NewSetter()
+	for _, s := range extra {
+		for key, value := range s.Data {
+			setter = setter.Set(key, value)
+		}
+	}
+	setter = setter.Set(column, to)
+	if result := r.guardColumns(setter); result != nil {
+		return false, result.Error
+	}
+	filled := r.fillMap(setter.Data)
+	columns := r.updatedColumns(filled)
+	data, err := r.encryptMap(filled)
+	if err != nil {
+		r.Reset()
+		return false, err
+	}
+
+	tx := r.guardedWrite("update", nil, func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: from}).Updates(data)
+	})
+	if tx.RowsAffected > 0 {
+		r.publish(tx, Updated[T]{Columns: columns, Result: tx})
+	}
+	return tx.RowsAffected > 0, tx.Error
+}
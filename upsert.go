@@ -0,0 +1,166 @@
+package gorme
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type upsertMode int
+
+const (
+	upsertDoNothing upsertMode = iota
+	upsertUpdateAll
+	upsertUpdateColumns
+	upsertUpdateExpr
+)
+
+// 插入冲突时的处理方式
+type OnConflict struct {
+	mode    upsertMode
+	columns []string
+	setter  Setter
+}
+
+// 冲突时忽略
+func DoNothing() OnConflict {
+	return OnConflict{mode: upsertDoNothing}
+}
+
+// 冲突时更新除主键和创建时间外的所有列
+func UpdateAll() OnConflict {
+	return OnConflict{mode: upsertUpdateAll}
+}
+
+// 冲突时把指定列更新为插入的值
+func UpdateColumns(columns ...string) OnConflict {
+	return OnConflict{mode: upsertUpdateColumns, columns: columns}
+}
+
+// 冲突时按Setter更新,插入的值用Excluded引用
+// 如 amount = amount + VALUES(amount):
+// UpdateExpr(repo.NewSetter().SetExpr("amount", "amount + ?", gorme.Excluded("amount")))
+func UpdateExpr(setter Setter) OnConflict {
+	return OnConflict{mode: upsertUpdateExpr, setter: setter}
+}
+
+// 引用冲突时插入的值,MySQL为VALUES(column),PostgreSQL和SQLite为excluded.column
+func Excluded(column string) clause.Expression {
+	return excluded{column: column}
+}
+
+type excluded struct {
+	column string
+}
+
+func (e excluded) Build(builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok && stmt.Dialector.Name() == "mysql" {
+		builder.WriteString("VALUES(")
+		builder.WriteQuoted(e.column)
+		builder.WriteByte(')')
+		return
+	}
+	builder.WriteQuoted(clause.Column{Table: "excluded", Name: e.column})
+}
+
+// 插入或更新,conflictColumns为判断冲突的唯一索引列
+// MySQL按主键和唯一索引判断冲突会忽略conflictColumns,PostgreSQL和SQLite为空时使用主键
+// 租户隔离的模型冲突时只更新本租户的行
+// 不可写的列不插入也不更新,按UpdateColumns、UpdateExpr更新不可写的列时返回*FieldAccessError
+func (r *Repository[T]) Upsert(rows any, conflictColumns []string, onConflict OnConflict) *WriteResult {
+	if result := r.guardInsert(rows); result != nil {
+		return result
+	}
+	//冲突时更新的列也需要可写
+	err := r.checkColumnsWritable(onConflict.columns...)
+	if err == nil {
+		err = r.checkWritable(onConflict.setter)
+	}
+	if err != nil {
+		r.Reset()
+		return &WriteResult{Error: err}
+	}
+	r.fillRows(r.rowsOf(rows), fillCreate)
+	conflict := clause.OnConflict{}
+	for _, column := range conflictColumns {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: column})
+	}
+	if len(conflict.Columns) == 0 && onConflict.mode != upsertDoNothing {
+		if s, err := r.schema(); err == nil {
+			for _, field := range s.PrimaryFields {
+				conflict.Columns = append(conflict.Columns, clause.Column{Name: field.DBName})
+			}
+		}
+	}
+
+	switch onConflict.mode {
+	case upsertDoNothing:
+		conflict.DoNothing = true
+	case upsertUpdateAll:
+		conflict.UpdateAll = true
+	case upsertUpdateColumns:
+		conflict.DoUpdates = clause.AssignmentColumns(onConflict.columns)
+	case upsertUpdateExpr:
+		conflict.DoUpdates = clause.Assignments(onConflict.setter.Data)
+	}
+
+	conflict = r.tenantConflict(conflict)
+	return r.execWrite("upsert", rows, 0, r.auditedConflict(rows, conflictColumns, func(db *gorm.DB) *gorm.DB {
+		return db.Clauses(conflict).Create(rows)
+	}))
+}
+
+// 插入,忽略冲突的行,不会改动已有的行,租户隔离的模型也可以使用
+// MySQL为INSERT IGNORE,PostgreSQL和SQLite为ON CONFLICT DO NOTHING
+func (r *Repository[T]) InsertIgnore(rows any) *WriteResult {
+	if result := r.guardInsert(rows); result != nil {
+		return result
+	}
+	r.fillRows(r.rowsOf(rows), fillCreate)
+	var expr clause.Expression = clause.OnConflict{DoNothing: true}
+	if r.DB.Dialector.Name() == "mysql" {
+		expr = clause.Insert{Modifier: "IGNORE"}
+	}
+	return r.execWrite("insert", rows, 0, r.auditedConflict(rows, nil, func(db *gorm.DB) *gorm.DB {
+		return db.Clauses(expr).Create(rows)
+	}))
+}
+
+// 插入,冲突时替换整行
+// MySQL为REPLACE INTO,SQLite为INSERT OR REPLACE,PostgreSQL为按主键冲突时更新所有列
+// MySQL和SQLite中租户隔离的模型返回ErrTenantReplace,PostgreSQL按Upsert只更新本租户的行
+// MySQL和SQLite中有不可写的列时返回*FieldAccessError,PostgreSQL按Upsert不写入这些列
+func (r *Repository[T]) Replace(rows any) *WriteResult {
+	if _, _, scoped, _ := r.tenant(); scoped && r.DB.Dialector.Name() != "postgres" {
+		r.Reset()
+		return &WriteResult{Error: ErrTenantReplace}
+	}
+	if r.DB.Dialector.Name() != "postgres" {
+		//替换整行会写入或清空不可写的列
+		if err := r.checkColumnsWritable(r.protectedColumns()...); err != nil {
+			r.Reset()
+			return &WriteResult{Error: err}
+		}
+	}
+	r.fillRows(r.rowsOf(rows), fillCreate)
+	var expr clause.Expression
+	switch r.DB.Dialector.Name() {
+	case "mysql":
+		expr = replaceInto{}
+	case "sqlite":
+		expr = clause.Insert{Modifier: "OR REPLACE"}
+	default:
+		return r.Upsert(rows, nil, UpdateAll())
+	}
+	return r.execWrite("replace", rows, 0, r.auditedConflict(rows, nil, func(db *gorm.DB) *gorm.DB {
+		return db.Clauses(expr).Create(rows)
+	}))
+}
+
+// 把INSERT INTO改为REPLACE INTO
+type replaceInto struct{}
+
+func (replaceInto) ModifyStatement(stmt *gorm.Statement) {
+	stmt.Clauses["INSERT"] = clause.Clause{Name: "REPLACE", Expression: clause.Insert{}}
+}
+
+func (replaceInto) Build(clause.Builder) {}